@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/codemk8/muser/pkg/webhook"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// AdminUserJSON is the view of a user for admins, the secret is reduced to
+// what an admin needs to know
+type AdminUserJSON struct {
+	ID                string              `json:"id,omitempty"`
+	UserName          string              `json:"user_name"`
+	DisplayName       string              `json:"display_name,omitempty"`
+	Created           int64               `json:"created,omitempty"`
+	Profile           schema.Profile      `json:"profile"`
+	Roles             []string            `json:"roles,omitempty"`
+	Groups            []string            `json:"groups,omitempty"`
+	InviteQuota       int                 `json:"invite_quota,omitempty"`
+	Status            string              `json:"status"`
+	StatusReason      string              `json:"status_reason,omitempty"`
+	StatusChanged     int64               `json:"status_changed,omitempty"`
+	StatusUntil       int64               `json:"status_until,omitempty"`
+	FailedLogins      int                 `json:"failed_logins,omitempty"`
+	PendingEmail      string              `json:"pending_email,omitempty"`
+	MustResetPassword bool                `json:"must_reset_password,omitempty"`
+	NameHistory       []schema.NameChange `json:"name_history,omitempty"`
+	DeletedAt         int64               `json:"deleted_at,omitempty"`
+}
+
+func adminView(user *schema.User) AdminUserJSON {
+	view := AdminUserJSON{
+		ID:                user.ID,
+		UserName:          user.UserName,
+		DisplayName:       user.DisplayName,
+		Created:           user.Created,
+		Profile:           user.Profile,
+		Roles:             user.Roles,
+		Groups:            user.Groups,
+		InviteQuota:       user.InviteQuota,
+		Status:            user.CurrentStatus(),
+		StatusReason:      user.StatusReason,
+		StatusChanged:     user.StatusChanged,
+		StatusUntil:       user.StatusUntil,
+		FailedLogins:      user.Secret.FailedLogins,
+		PendingEmail:      user.Secret.PendingEmail,
+		MustResetPassword: user.Secret.MustResetPassword,
+		NameHistory:       user.NameHistory,
+		DeletedAt:         user.DeletedAt,
+	}
+	return view
+}
+
+// StatusJSON changes the status of an account, until is the unix time a
+// suspension or lock ends, 0 is never
+type StatusJSON struct {
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Until  int64  `json:"until,omitempty"`
+}
+
+// RolesJSON replaces the roles of a user
+type RolesJSON struct {
+	Roles []string `json:"roles"`
+}
+
+// ResetPasswordJSON completes a password reset forced by an admin
+type ResetPasswordJSON struct {
+	UserName    string `json:"user_name,omitempty"`
+	Token       string `json:"token,omitempty"`
+	NewPassword string `json:"new_password,omitempty"`
+}
+
+func (reset ResetPasswordJSON) Validate() error {
+	return validation.ValidateStruct(&reset,
+		validation.Field(&reset.UserName, validation.Required),
+		validation.Field(&reset.Token, validation.Required),
+		validation.Field(&reset.NewPassword, validation.Required, validation.Length(7, 32)))
+}
+
+// pathUser loads the user named in the path, it writes the error response
+// and returns nil if there is no such user
+func pathUser(w http.ResponseWriter, r *http.Request) *schema.User {
+	dbUser, err := store(r).GetUser(mux.Vars(r)["name"], true)
+	if err != nil {
+		writeUserNotFound(w, r, mux.Vars(r)["name"], http.StatusNotFound, "user not found")
+		return nil
+	}
+	return dbUser
+}
+
+// notSelf rejects admin actions that would lock the caller out
+func notSelf(w http.ResponseWriter, r *http.Request, action string) bool {
+	if userKey(r, mux.Vars(r)["name"]) == caller(r).UserName {
+		http.Error(w, "can not "+action+" yourself", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// userFilter reads the filters of a user listing: q and email are
+// prefixes of the user name and email, status is the account status, verified is true or false and
+// created_after and created_before are unix timestamps
+func userFilter(r *http.Request) (dynamo.UserFilter, error) {
+	query := r.URL.Query()
+	filter := dynamo.UserFilter{Prefix: prefixKey(query.Get("q")), EmailPrefix: query.Get("email"), Status: query.Get("status")}
+	if v := query.Get("verified"); v != "" {
+		verified, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Verified = &verified
+	}
+	var err error
+	if v := query.Get("created_after"); v != "" {
+		filter.CreatedAfter, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if v := query.Get("created_before"); v != "" {
+		filter.CreatedBefore, err = strconv.ParseInt(v, 10, 64)
+	}
+	return filter, err
+}
+
+// prefixKey is the form of a user name prefix the keys start with, see
+// schema.CanonicalUserName. A prefix no name can start with is only lower
+// cased, it matches no user.
+func prefixKey(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	key, err := schema.CanonicalUserName(prefix)
+	if err != nil {
+		return strings.ToLower(prefix)
+	}
+	return key
+}
+
+func listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := userFilter(r)
+	if err != nil {
+		http.Error(w, "bad filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursor, limit := pageParams(r)
+	users, next, err := store(r).ListUsers(filter, cursor, limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	views := make([]AdminUserJSON, len(users))
+	for i := range users {
+		views[i] = adminView(&users[i])
+	}
+	writeJSON(w, PageJSON{Items: views, Cursor: next})
+}
+
+func adminGetUserHandler(w http.ResponseWriter, r *http.Request) {
+	dbUser := pathUser(w, r)
+	if dbUser == nil {
+		return
+	}
+	writeJSON(w, adminView(dbUser))
+}
+
+// setStatus sets the status of the user in the path, admins can not block
+// themselves
+func setStatus(w http.ResponseWriter, r *http.Request, req StatusJSON) {
+	if !schema.ValidStatus(req.Status) || req.Status == schema.StatusDeleted {
+		http.Error(w, "bad request, unknown status "+req.Status, http.StatusBadRequest)
+		return
+	}
+	if req.Status != schema.StatusActive && !notSelf(w, r, "block") {
+		return
+	}
+	name := userKey(r, mux.Vars(r)["name"])
+	err := store(r).SetUserStatus(name, req.Status, req.Reason, req.Until)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("User %s set the status of %s to %s: %s", caller(r).UserName, name, req.Status, req.Reason)
+}
+
+func setStatusHandler(w http.ResponseWriter, r *http.Request) {
+	req := StatusJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	setStatus(w, r, req)
+}
+
+// disableUserHandler suspends the user, the body with the reason and end of
+// the suspension is optional
+func disableUserHandler(w http.ResponseWriter, r *http.Request) {
+	req := StatusJSON{}
+	json.NewDecoder(r.Body).Decode(&req)
+	req.Status = schema.StatusSuspended
+	setStatus(w, r, req)
+}
+
+// enableUserHandler activates the user, lifting a suspension or lock
+func enableUserHandler(w http.ResponseWriter, r *http.Request) {
+	setStatus(w, r, StatusJSON{Status: schema.StatusActive})
+}
+
+// forceResetHandler blocks the user until the password is reset with the
+// link sent to the user's verified email
+func forceResetHandler(w http.ResponseWriter, r *http.Request) {
+	dbUser := pathUser(w, r)
+	if dbUser == nil {
+		return
+	}
+	token := schema.GenToken()
+	var note *verify.VerifyRequest
+	if dbUser.Profile.Email != "" && dbUser.Profile.Verified {
+		query := url.Values{}
+		query.Set("user_name", dbUser.UserName)
+		query.Set("token", token)
+		note = &verify.VerifyRequest{
+			Type:     verify.TypePasswordReset,
+			UserName: dbUser.UserName,
+			To:       dbUser.Profile.Email,
+			Locale:   dbUser.Profile.Locale,
+			Link:     apiURL(r) + "/user/password/reset?" + query.Encode(),
+		}
+	}
+	expiry := time.Now().Add(*resetExpiry).Unix()
+	err := store(r).ForcePasswordReset(dbUser.UserName, schema.HashToken(token), expiry, note)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("User %s forced a password reset of %s", caller(r).UserName, dbUser.UserName)
+	if note == nil {
+		// nowhere to send the token, the admin hands it over
+		writeJSON(w, map[string]string{"token": token})
+	}
+}
+
+func forceVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	dbUser := pathUser(w, r)
+	if dbUser == nil {
+		return
+	}
+	if dbUser.Profile.Email == "" && dbUser.Secret.PendingEmail == "" {
+		http.Error(w, "user has no email", http.StatusBadRequest)
+		return
+	}
+	emailChanged := dbUser.Secret.PendingEmail != ""
+	notes := promotePendingEmail(r, dbUser)
+	err := store(r).ForceVerifyEmail(dbUser, notes...)
+	if err == dynamo.ErrEmailTaken {
+		writeError(w, http.StatusConflict, codeEmailTaken, "The email belongs to another account")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("User %s verified the email of %s", caller(r).UserName, dbUser.UserName)
+	webhooks.Publish(webhook.UserVerified, store(r).Tenant(), dbUser.UserName, nil)
+	if emailChanged {
+		webhooks.Publish(webhook.UserEmailChanged, store(r).Tenant(), dbUser.UserName, map[string]interface{}{"email": dbUser.Profile.Email})
+	}
+}
+
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if !notSelf(w, r, "delete") {
+		return
+	}
+	dbUser := pathUser(w, r)
+	if dbUser == nil {
+		return
+	}
+	err := purgeUser(store(r), dbUser)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("User %s deleted %s", caller(r).UserName, dbUser.UserName)
+}
+
+func setRolesHandler(w http.ResponseWriter, r *http.Request) {
+	req := RolesJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	roles := []string{}
+	for _, role := range req.Roles {
+		if !schema.ValidRole(role) {
+			http.Error(w, "bad request, unknown role "+role, http.StatusBadRequest)
+			return
+		}
+		// every user has the user role
+		if role != schema.RoleUser {
+			roles = append(roles, role)
+		}
+	}
+	name := userKey(r, mux.Vars(r)["name"])
+	if name == caller(r).UserName && caller(r).HasRole(schema.RoleAdmin) &&
+		!(&schema.User{Roles: roles}).HasRole(schema.RoleAdmin) {
+		http.Error(w, "can not revoke your own admin role", http.StatusBadRequest)
+		return
+	}
+	err = store(r).SetRoles(name, roles)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("User %s set the roles of %s to %v", caller(r).UserName, name, roles)
+}
+
+// resetPageHandler answers the link of a forced reset with a page setting
+// the new password
+func resetPageHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("user_name")
+	token := r.URL.Query().Get("token")
+	if username == "" || token == "" {
+		http.Error(w, "bad request, needs user_name and token", http.StatusBadRequest)
+		return
+	}
+	writePage(w, "reset", pageData{UserName: username, Token: token, Action: r.URL.Path})
+}
+
+// resetPasswordHandler sets a new password with the token of a reset
+// forced by an admin
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	req := ResetPasswordJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = req.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+	dbUser, err := getLinkedUser(r, req.UserName)
+	if err != nil {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+	if !dbUser.Secret.MustResetPassword || !schema.CheckToken(req.Token, dbUser.Secret.ResetToken) {
+		http.Error(w, "invalid reset token", http.StatusBadRequest)
+		return
+	}
+	if time.Now().Unix() >= dbUser.Secret.ResetExpiry {
+		http.Error(w, "reset token expired, ask an admin for a new one", http.StatusBadRequest)
+		return
+	}
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	err = store(r).ResetPassword(dbUser.UserName, hash)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	webhooks.Publish(webhook.UserPasswordChanged, store(r).Tenant(), dbUser.UserName, nil)
+}
+
+// adminRoutes adds the user administration routes to r
+func adminRoutes(r *mux.Router) {
+	r.HandleFunc("/admin/users", requirePermission(schema.PermUsersAdmin, listUsersHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{name}", requirePermission(schema.PermUsersAdmin, adminGetUserHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{name}", requirePermission(schema.PermUsersAdmin, deleteUserHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{name}/disable", requirePermission(schema.PermUsersAdmin, disableUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/{name}/enable", requirePermission(schema.PermUsersAdmin, enableUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/{name}/status", requirePermission(schema.PermUsersAdmin, setStatusHandler)).Methods("PUT")
+	r.HandleFunc("/admin/users/{name}/reset-password", requirePermission(schema.PermUsersAdmin, forceResetHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/{name}/verify", requirePermission(schema.PermUsersAdmin, forceVerifyHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/{name}/roles", requirePermission(schema.PermUsersAdmin, setRolesHandler)).Methods("PUT")
+	r.HandleFunc("/admin/users/{name}/rename", requirePermission(schema.PermUsersAdmin, adminRenameHandler)).Methods("POST")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListUsersPrefix(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "admin", "secret1", func(user *schema.User) {
+		user.Roles = []string{schema.RoleAdmin}
+	})
+	addUser(t, "alice", "secret1", nil)
+	addUser(t, "alfred", "secret1", nil)
+	addUser(t, "bob", "secret1", nil)
+	for q, count := range map[string]int{
+		"al":    2,
+		"AL":    2,
+		"Ａｌｉ":   1,
+		"bob":   1,
+		"a b":   0,
+		"carol": 0,
+	} {
+		w := serve("GET", "/v1/admin/users?q="+url.QueryEscape(q), "", "admin", "secret1")
+		assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+		items, _ := decodeBody(t, w)["items"].([]interface{})
+		assert.Equal(t, len(items), count, q)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/codemk8/muser/pkg/schema"
+)
+
+// profileAttributes are the custom profile attributes of --profile_attributes
+var profileAttributes schema.AttributeSchema
+
+// attributeSchema returns the custom attributes of the request's tenant,
+// which replace the server's if the tenant declares any
+func attributeSchema(r *http.Request) schema.AttributeSchema {
+	tenant := tenantOf(r)
+	if tenant != nil && len(tenant.Settings.Attributes) > 0 {
+		return tenant.Settings.Attributes
+	}
+	return profileAttributes
+}
+
+// validTimezone is a validation rule for IANA time zone names
+func validTimezone(value interface{}) error {
+	name, _ := value.(string)
+	if name == "" {
+		return nil
+	}
+	// LoadLocation also accepts "Local", which depends on the server
+	if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+		return errors.New("must be an IANA time zone")
+	}
+	return nil
+}
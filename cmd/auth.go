@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+type contextKey int
+
+const callerKey contextKey = 0
+
+// authenticate checks the basic auth credentials of the request, it writes
+// the error response and returns nil if they are missing or wrong
+func authenticate(w http.ResponseWriter, r *http.Request) *schema.User {
+	username, password, authOK := r.BasicAuth()
+	if authOK == false {
+		glog.Warning("Failed to parse basic auth from header")
+		writeInvalidCredentials(w)
+		return nil
+	}
+	user, err := store(r).GetUser(username, true)
+	if err != nil {
+		glog.Warningf("Failed to get user from db: %v.", err)
+		writeInvalidCredentials(w)
+		return nil
+	}
+	if !checkPassword(w, r, user, password) || !admitted(w, r, user) {
+		return nil
+	}
+	// users created before ids get one on their next sign in
+	err = store(r).EnsureUserID(user)
+	if err != nil {
+		glog.Warningf("Failed to assign an id to %s: %v", user.UserName, err)
+	}
+	return user
+}
+
+// admitted tells if a user whose password was checked may sign in, it
+// writes the error response otherwise
+func admitted(w http.ResponseWriter, r *http.Request, user *schema.User) bool {
+	if !user.Active() {
+		glog.Warningf("User %s is %s", user.UserName, user.CurrentStatus())
+		writeStatusError(w, user)
+		return false
+	}
+	if unverified(r, user) {
+		writeError(w, http.StatusForbidden, codeEmailNotVerified, "Verify your email to sign in")
+		return false
+	}
+	return true
+}
+
+// requirePermission only lets authenticated callers having perm through,
+// an empty perm requires authentication only. The caller is available to
+// the handler with caller(r).
+func requirePermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := authenticate(w, r)
+		if user == nil {
+			return
+		}
+		if perm != "" && !user.HasPermission(perm) {
+			glog.Warningf("User %s lacks permission %s for %s", user.UserName, perm, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey, user)))
+	}
+}
+
+// allowAnonymous lets callers without credentials through to next, where
+// caller(r) is nil. Callers sending credentials must have perm.
+func allowAnonymous(perm string, next http.HandlerFunc) http.HandlerFunc {
+	authenticated := requirePermission(perm, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok {
+			next(w, r)
+			return
+		}
+		authenticated(w, r)
+	}
+}
+
+// userKey returns the key of the user a client names in any form, see
+// schema.CanonicalUserName, or the name itself if there is no such user
+func userKey(r *http.Request, name string) string {
+	dbUser, err := store(r).GetUser(name, false)
+	if err != nil {
+		return name
+	}
+	return dbUser.UserName
+}
+
+// caller returns the user authenticated by requirePermission
+func caller(r *http.Request) *schema.User {
+	user, _ := r.Context().Value(callerKey).(*schema.User)
+	return user
+}
+
+// bootstrapAdmin creates the admin user on the first start, with the
+// password from $MUSER_ADMIN_PASSWORD or a random one written to
+// --admin_password_file, never to the log. A user already named
+// --admin_user is only promoted with --promote_existing, and keeps its
+// password.
+func bootstrapAdmin() error {
+	password := os.Getenv("MUSER_ADMIN_PASSWORD")
+	generated := password == ""
+	tmp := *adminPasswordFile + ".tmp"
+	if generated {
+		password = schema.GenToken()[:20]
+		// written before the admin exists, a password nobody can read is lost
+		err := ioutil.WriteFile(tmp, []byte(password+"\n"), 0600)
+		if err != nil {
+			return fmt.Errorf("can't write the admin password: %v", err)
+		}
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	created, err := client.Bootstrap(schema.NewUser(*adminUser, hash), *promoteExisting)
+	if generated && (err != nil || !created) {
+		os.Remove(tmp)
+	}
+	if err == dynamo.ErrExists {
+		return fmt.Errorf("user %s already exists, promote it with --promote_existing or pick another --admin_user", *adminUser)
+	}
+	if err != nil {
+		return err
+	}
+	if created && generated {
+		err = os.Rename(tmp, *adminPasswordFile)
+		if err != nil {
+			return fmt.Errorf("can't move the admin password from %s: %v", tmp, err)
+		}
+		glog.Warningf("Created admin user %s, its password is in %s, change it and delete the file", *adminUser, *adminPasswordFile)
+	} else if created {
+		glog.Infof("Created admin user %s", *adminUser)
+	}
+	return nil
+}
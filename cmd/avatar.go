@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codemk8/muser/pkg/avatar"
+	"github.com/codemk8/muser/pkg/blob"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+// Avatars are uploaded as images, rendered as thumbnails of --avatar_sizes
+// and kept in the blob store under <tenant>/<user id>/<version>/<size><ext>.
+// Profile.Avatar is the URL of the largest one.
+
+// avatars is the blob store of --avatar_store
+var avatars blob.Store
+
+// avatarSizes are the thumbnail sizes of --avatar_sizes, largest first
+var avatarSizes []int
+
+// avatarDecoders holds a slot per upload being decoded, at most
+// --avatar_decoders, each can take a few hundred MB
+var avatarDecoders chan struct{}
+
+// AvatarJSON answers an upload with the URLs of the thumbnails by size
+type AvatarJSON struct {
+	Avatar     string            `json:"avatar"`
+	Thumbnails map[string]string `json:"thumbnails"`
+}
+
+// parseSizes reads a comma separated list of thumbnail sizes
+func parseSizes(list string) ([]int, error) {
+	sizes := []int{}
+	for _, field := range strings.Split(list, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 16 || size > 1024 {
+			return nil, strconv.ErrRange
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	return sizes, nil
+}
+
+// avatarKey is the key of the thumbnail of size in the avatar directory
+func avatarKey(dir string, size int, ext string) string {
+	return dir + "/" + strconv.Itoa(size) + ext
+}
+
+// avatarDir is a new directory for the avatar of user, a new one per
+// upload so caches never serve a stale image
+func avatarDir(r *http.Request, user *schema.User) string {
+	tenant := store(r).Tenant()
+	if tenant == "" {
+		// tenant names can not start with "_"
+		tenant = "_"
+	}
+	return tenant + "/" + user.ID + "/" + schema.GenToken()[:12]
+}
+
+// deleteAvatar removes the thumbnails of an avatar URL, URLs not from the
+// store are left alone
+func deleteAvatar(url string) {
+	key := blob.KeyOf(avatars, url)
+	if key == "" {
+		return
+	}
+	dir, ext := path.Dir(key), path.Ext(key)
+	for _, size := range avatarSizes {
+		err := avatars.Delete(avatarKey(dir, size, ext))
+		if err != nil {
+			glog.Warningf("Failed to delete avatar %s: %v", avatarKey(dir, size, ext), err)
+		}
+	}
+}
+
+// uploadAvatarHandler replaces the avatar of the caller with the image in
+// the "avatar" field of a multipart form
+func uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user := caller(r)
+	if user.ID == "" {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	// room for the multipart framing around the file
+	limit := *avatarMaxBytes + 64*1024
+	if r.ContentLength > limit {
+		http.Error(w, "avatar too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	err := r.ParseMultipartForm(*avatarMaxBytes)
+	if err != nil {
+		http.Error(w, "bad request, expected a multipart form with an avatar of at most "+strconv.FormatInt(*avatarMaxBytes, 10)+" bytes", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		http.Error(w, "bad request, no avatar file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > *avatarMaxBytes {
+		http.Error(w, "avatar too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	select {
+	case avatarDecoders <- struct{}{}:
+	case <-r.Context().Done():
+		return
+	}
+	thumbs, err := avatar.Process(data, avatarSizes)
+	<-avatarDecoders
+	switch err {
+	case nil:
+	case avatar.ErrUnsupported:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case avatar.ErrTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	default:
+		glog.Warningf("Failed to process avatar of %s: %v", user.UserName, err)
+		http.Error(w, "bad request, unreadable image", http.StatusBadRequest)
+		return
+	}
+	dir := avatarDir(r, user)
+	resp := AvatarJSON{Thumbnails: map[string]string{}}
+	for _, thumb := range thumbs {
+		key := avatarKey(dir, thumb.Size, thumb.Ext)
+		err = avatars.Put(key, thumb.ContentType, thumb.Data)
+		if err != nil {
+			glog.Warningf("Failed to store avatar %s: %v", key, err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		resp.Thumbnails[strconv.Itoa(thumb.Size)] = avatars.URL(key)
+	}
+	resp.Avatar = avatars.URL(avatarKey(dir, thumbs[0].Size, thumbs[0].Ext))
+	previous := user.Profile.Avatar
+	user.Profile.Avatar = resp.Avatar
+	err = store(r).SaveUser(user)
+	if err != nil {
+		glog.Warningf("Error saving avatar of %s: %v", user.UserName, err)
+		deleteAvatar(resp.Avatar)
+		writeStoreError(w, err)
+		return
+	}
+	deleteAvatar(previous)
+	writeJSON(w, resp)
+}
+
+// deleteAvatarHandler removes the avatar of the caller
+func deleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user := caller(r)
+	previous := user.Profile.Avatar
+	if previous != "" {
+		user.Profile.Avatar = ""
+		err := store(r).SaveUser(user)
+		if err != nil {
+			glog.Warningf("Error removing avatar of %s: %v", user.UserName, err)
+			writeStoreError(w, err)
+			return
+		}
+		deleteAvatar(previous)
+	}
+	writeJSON(w, selfView(user))
+}
+
+// serveAvatars serves the files of the local avatar store, without
+// directory listings
+func serveAvatars(prefix string, dir string) http.Handler {
+	files := http.StripPrefix(prefix, http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		files.ServeHTTP(w, r)
+	})
+}
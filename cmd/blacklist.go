@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/codemk8/muser/pkg/blacklist"
+	"github.com/codemk8/muser/pkg/schema"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/golang/glog"
+)
+
+// BlacklistJSON adds a name or pattern to the blacklist
+type BlacklistJSON struct {
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// validPatterns is a validation rule for a list of blacklist patterns
+func validPatterns(value interface{}) error {
+	patterns, _ := value.([]string)
+	_, err := blacklist.Compile(patterns)
+	return err
+}
+
+func (req BlacklistJSON) Validate() error {
+	return validation.ValidateStruct(&req,
+		validation.Field(&req.Pattern, validation.Required, validation.Length(1, 256),
+			validation.By(func(value interface{}) error {
+				return validPatterns([]string{req.Pattern})
+			})),
+	)
+}
+
+func listBlacklistHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := client.ListBlacklist()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+func addBlacklistHandler(w http.ResponseWriter, r *http.Request) {
+	req := BlacklistJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = req.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+	entry := &schema.BlacklistEntry{Pattern: req.Pattern, CreatedBy: caller(r).UserName, Created: time.Now().Unix()}
+	err = client.AddBlacklist(entry)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	refreshBlacklist()
+	glog.Infof("User %s blacklisted %s", caller(r).UserName, req.Pattern)
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, entry)
+}
+
+// removeBlacklistHandler removes the pattern given as query parameter,
+// patterns do not fit in a path
+func removeBlacklistHandler(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		http.Error(w, "bad request, needs pattern", http.StatusBadRequest)
+		return
+	}
+	err := client.RemoveBlacklist(pattern)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	refreshBlacklist()
+	glog.Infof("User %s removed %s from the blacklist", caller(r).UserName, pattern)
+}
+
+func refreshBlacklist() {
+	err := client.RefreshBlacklist()
+	if err != nil {
+		glog.Warningf("Failed to refresh the blacklist: %v", err)
+	}
+}
+
+func refreshBlacklistEvery(period time.Duration) {
+	for range time.Tick(period) {
+		refreshBlacklist()
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/codemk8/muser/pkg/webhook"
+	"github.com/golang/glog"
+)
+
+// Users delete their own account by soft delete, it can be restored during
+// --delete_grace and is purged by purgeDeleted afterwards.
+
+// credentials decodes the user name and password of a request and checks
+// them, it writes the error response and returns nil if they are wrong
+func credentials(w http.ResponseWriter, r *http.Request) *schema.User {
+	req := UserJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.UserName == "" || req.Password == "" {
+		http.Error(w, "bad request, needs usename and password", http.StatusBadRequest)
+		return nil
+	}
+	dbUser, err := store(r).GetUser(req.UserName, true)
+	if err != nil {
+		writeInvalidCredentials(w)
+		return nil
+	}
+	if !checkPassword(w, r, dbUser, req.Password) {
+		return nil
+	}
+	return dbUser
+}
+
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	dbUser := credentials(w, r)
+	if dbUser == nil {
+		return
+	}
+	if !selfService(dbUser) {
+		writeStatusError(w, dbUser)
+		return
+	}
+	softDelete(w, r, dbUser)
+}
+
+// softDelete deletes the account of dbUser, restorable during
+// --delete_grace, it writes the error response and returns false if it
+// fails
+func softDelete(w http.ResponseWriter, r *http.Request, dbUser *schema.User) bool {
+	now := time.Now()
+	// a restore must not get the account out of its status
+	if status := dbUser.CurrentStatus(); status != schema.StatusActive {
+		dbUser.RestoreStatus = status
+		dbUser.RestoreReason = dbUser.StatusReason
+	}
+	dbUser.Status = schema.StatusDeleted
+	dbUser.StatusReason = ""
+	dbUser.DeletedAt = now.Unix()
+	notes := []verify.VerifyRequest{}
+	if dbUser.Profile.Verified {
+		notes = append(notes, verify.VerifyRequest{
+			Type:     verify.TypeSecurityAlert,
+			UserName: dbUser.UserName,
+			To:       dbUser.Profile.Email,
+			Locale:   dbUser.Profile.Locale,
+			Event:    verify.EventAccountDeleted,
+			Time:     now.Add(*deleteGrace).Unix(),
+		})
+	}
+	err := store(r).SaveUser(dbUser, notes...)
+	if err != nil {
+		glog.Warningf("Error deleting user: %v", err)
+		writeStoreError(w, err)
+		return false
+	}
+	glog.Infof("User %s deleted the account", dbUser.UserName)
+	return true
+}
+
+func restoreAccountHandler(w http.ResponseWriter, r *http.Request) {
+	dbUser := credentials(w, r)
+	if dbUser == nil {
+		return
+	}
+	if dbUser.Status != schema.StatusDeleted {
+		http.Error(w, "account is not deleted", http.StatusBadRequest)
+		return
+	}
+	if time.Since(time.Unix(dbUser.DeletedAt, 0)) > *deleteGrace {
+		// waiting to be purged
+		http.Error(w, "account can no longer be restored", http.StatusGone)
+		return
+	}
+	err := store(r).RestoreUser(dbUser)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("User %s restored the account", dbUser.UserName)
+}
+
+// purgeUser hard deletes a user, reserving the name if --deleted_names is
+// reserve
+func purgeUser(s *dynamo.DynamoClient, user *schema.User) error {
+	var res *schema.Reservation
+	if *deletedNames == "reserve" {
+		res = schema.NewReservation(user.UserName, "deleted", *reservePeriod)
+	}
+	err := s.PurgeUser(user, res)
+	if err != nil {
+		return err
+	}
+	deleteAvatar(user.Profile.Avatar)
+	webhooks.Publish(webhook.UserDeleted, s.Tenant(), user.UserName, nil)
+	return nil
+}
+
+// purgeDeleted purges the users of every tenant deleted for longer than
+// --delete_grace, a user or tenant failing to purge is skipped until the
+// next run
+func purgeDeleted() error {
+	list, err := client.ListTenants()
+	if err != nil {
+		return err
+	}
+	stores := []*dynamo.DynamoClient{client}
+	for i := range list {
+		stores = append(stores, client.ForTenant(&list[i]))
+	}
+	before := time.Now().Add(-*deleteGrace).Unix()
+	filter := dynamo.UserFilter{Status: schema.StatusDeleted}
+	for _, s := range stores {
+		cursor := ""
+		for {
+			users, next, err := s.ListUsers(filter, cursor, maxPageSize)
+			if err != nil {
+				glog.Warningf("Failed to list deleted users of tenant %q: %v", s.Tenant(), err)
+				break
+			}
+			for i := range users {
+				if users[i].DeletedAt > before {
+					continue
+				}
+				err = purgeUser(s, &users[i])
+				if err != nil {
+					// left for the next run, the others still go
+					glog.Warningf("Failed to purge user %s of tenant %q: %v", users[i].UserName, s.Tenant(), err)
+					continue
+				}
+				glog.Infof("Purged user %s of tenant %q", users[i].UserName, s.Tenant())
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+	return nil
+}
+
+func purgeEvery(period time.Duration) {
+	for range time.Tick(period) {
+		err := purgeDeleted()
+		if err != nil {
+			glog.Warningf("Failed to purge deleted users: %v", err)
+		}
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeDB is an in-memory table keyed by user_name, with the kind index,
+// evaluating the condition and update expressions the client writes. Calls
+// it does not implement panic through the embedded nil interface.
+type fakeDB struct {
+	dynamodbiface.DynamoDBAPI
+	mu    sync.Mutex
+	items map[string]item
+}
+
+type item = map[string]*dynamodb.AttributeValue
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{items: map[string]item{}}
+}
+
+func (db *fakeDB) Scan(in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ex := exprContext{names: in.ExpressionAttributeNames, values: in.ExpressionAttributeValues}
+	out := &dynamodb.ScanOutput{}
+	for _, key := range db.sortedKeys() {
+		it := db.items[key]
+		ok, err := ex.matches(aws.StringValue(in.FilterExpression), it)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out.Items = append(out.Items, ex.project(aws.StringValue(in.ProjectionExpression), it))
+		}
+		if in.Limit != nil && int64(len(out.Items)) >= *in.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (db *fakeDB) Query(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ex := exprContext{names: in.ExpressionAttributeNames, values: in.ExpressionAttributeValues}
+	out := &dynamodb.QueryOutput{}
+	for _, key := range db.sortedKeys() {
+		it := db.items[key]
+		if in.IndexName != nil && it["kind"] == nil {
+			continue
+		}
+		ok, err := ex.matches(aws.StringValue(in.KeyConditionExpression), it)
+		if err == nil && ok {
+			ok, err = ex.matches(aws.StringValue(in.FilterExpression), it)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out.Items = append(out.Items, ex.project(aws.StringValue(in.ProjectionExpression), it))
+		}
+		if in.Limit != nil && int64(len(out.Items)) >= *in.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (db *fakeDB) QueryPages(in *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	out, err := db.Query(in)
+	if err != nil {
+		return err
+	}
+	fn(out, true)
+	return nil
+}
+
+func (db *fakeDB) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	it, ok := db.items[keyOf(in.Key)]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	ex := exprContext{names: in.ExpressionAttributeNames}
+	return &dynamodb.GetItemOutput{Item: ex.project(aws.StringValue(in.ProjectionExpression), it)}, nil
+}
+
+func (db *fakeDB) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	put := &dynamodb.Put{Item: in.Item, ConditionExpression: in.ConditionExpression,
+		ExpressionAttributeNames: in.ExpressionAttributeNames, ExpressionAttributeValues: in.ExpressionAttributeValues}
+	err := db.write(&dynamodb.TransactWriteItem{Put: put}, false)
+	return &dynamodb.PutItemOutput{}, err
+}
+
+func (db *fakeDB) DeleteItem(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	del := &dynamodb.Delete{Key: in.Key, ConditionExpression: in.ConditionExpression,
+		ExpressionAttributeNames: in.ExpressionAttributeNames, ExpressionAttributeValues: in.ExpressionAttributeValues}
+	err := db.write(&dynamodb.TransactWriteItem{Delete: del}, false)
+	return &dynamodb.DeleteItemOutput{}, err
+}
+
+func (db *fakeDB) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	update := &dynamodb.Update{Key: in.Key, UpdateExpression: in.UpdateExpression, ConditionExpression: in.ConditionExpression,
+		ExpressionAttributeNames: in.ExpressionAttributeNames, ExpressionAttributeValues: in.ExpressionAttributeValues}
+	err := db.write(&dynamodb.TransactWriteItem{Update: update}, false)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: db.items[keyOf(in.Key)]}, nil
+}
+
+func (db *fakeDB) TransactWriteItems(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	reasons := make([]string, len(in.TransactItems))
+	failed := false
+	for i, op := range in.TransactItems {
+		reasons[i] = "None"
+		err := db.write(op, true)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+				return nil, err
+			}
+			reasons[i] = "ConditionalCheckFailed"
+			failed = true
+		}
+	}
+	if failed {
+		return nil, awserr.New(dynamodb.ErrCodeTransactionCanceledException,
+			"Transaction cancelled, please refer cancellation reasons for specific reasons ["+strings.Join(reasons, ", ")+"]", nil)
+	}
+	for _, op := range in.TransactItems {
+		err := db.write(op, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// write checks the condition of op and applies it unless checkOnly
+func (db *fakeDB) write(op *dynamodb.TransactWriteItem, checkOnly bool) error {
+	var key, cond string
+	var ex exprContext
+	switch {
+	case op.Put != nil:
+		key, cond = keyOf(op.Put.Item), aws.StringValue(op.Put.ConditionExpression)
+		ex = exprContext{names: op.Put.ExpressionAttributeNames, values: op.Put.ExpressionAttributeValues}
+	case op.Delete != nil:
+		key, cond = keyOf(op.Delete.Key), aws.StringValue(op.Delete.ConditionExpression)
+		ex = exprContext{names: op.Delete.ExpressionAttributeNames, values: op.Delete.ExpressionAttributeValues}
+	case op.Update != nil:
+		key, cond = keyOf(op.Update.Key), aws.StringValue(op.Update.ConditionExpression)
+		ex = exprContext{names: op.Update.ExpressionAttributeNames, values: op.Update.ExpressionAttributeValues}
+	case op.ConditionCheck != nil:
+		key, cond = keyOf(op.ConditionCheck.Key), aws.StringValue(op.ConditionCheck.ConditionExpression)
+		ex = exprContext{names: op.ConditionCheck.ExpressionAttributeNames, values: op.ConditionCheck.ExpressionAttributeValues}
+	}
+	current := db.items[key]
+	ok, err := ex.matches(cond, current)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	if checkOnly {
+		return nil
+	}
+	switch {
+	case op.Put != nil:
+		db.items[key] = copyItem(op.Put.Item)
+	case op.Delete != nil:
+		delete(db.items, key)
+	case op.Update != nil:
+		updated := copyItem(current)
+		if updated == nil {
+			updated = copyItem(op.Update.Key)
+		}
+		err = ex.update(aws.StringValue(op.Update.UpdateExpression), updated)
+		if err != nil {
+			return err
+		}
+		db.items[key] = updated
+	}
+	return nil
+}
+
+func (db *fakeDB) sortedKeys() []string {
+	keys := make([]string, 0, len(db.items))
+	for key := range db.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func keyOf(it item) string {
+	return aws.StringValue(it["user_name"].S)
+}
+
+func copyItem(it item) item {
+	if it == nil {
+		return nil
+	}
+	c := item{}
+	for k, v := range it {
+		c[k] = v
+	}
+	return c
+}
+
+// exprContext evaluates expressions with their placeholders
+type exprContext struct {
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+	tokens []string
+	pos    int
+}
+
+func tokenize(s string) []string {
+	tokens := []string{}
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("(),=+-.[]", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '<' || c == '>':
+			if i+1 < len(s) && (s[i+1] == '=' || s[i+1] == '>') {
+				tokens = append(tokens, s[i:i+2])
+				i += 2
+			} else {
+				tokens = append(tokens, string(c))
+				i++
+			}
+		default:
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '#' || s[j] == ':') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+func (ex *exprContext) start(s string) {
+	ex.tokens = tokenize(s)
+	ex.pos = 0
+}
+
+func (ex *exprContext) peek() string {
+	if ex.pos < len(ex.tokens) {
+		return ex.tokens[ex.pos]
+	}
+	return ""
+}
+
+func (ex *exprContext) next() string {
+	t := ex.peek()
+	ex.pos++
+	return t
+}
+
+func (ex *exprContext) expect(t string) error {
+	if got := ex.next(); got != t {
+		return fmt.Errorf("expected %q, got %q in %v", t, got, ex.tokens)
+	}
+	return nil
+}
+
+// matches evaluates a condition on it, an empty condition always holds
+func (ex exprContext) matches(cond string, it item) (bool, error) {
+	if cond == "" {
+		return true, nil
+	}
+	ex.start(cond)
+	ok, err := ex.or(it)
+	if err == nil && ex.pos != len(ex.tokens) {
+		err = fmt.Errorf("trailing tokens in %q", cond)
+	}
+	return ok, err
+}
+
+func (ex *exprContext) or(it item) (bool, error) {
+	ok, err := ex.and(it)
+	for err == nil && strings.EqualFold(ex.peek(), "OR") {
+		ex.next()
+		var right bool
+		right, err = ex.and(it)
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (ex *exprContext) and(it item) (bool, error) {
+	ok, err := ex.not(it)
+	for err == nil && strings.EqualFold(ex.peek(), "AND") {
+		ex.next()
+		var right bool
+		right, err = ex.not(it)
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (ex *exprContext) not(it item) (bool, error) {
+	if strings.EqualFold(ex.peek(), "NOT") {
+		ex.next()
+		ok, err := ex.not(it)
+		return !ok, err
+	}
+	return ex.primary(it)
+}
+
+func (ex *exprContext) primary(it item) (bool, error) {
+	if ex.peek() == "(" {
+		ex.next()
+		ok, err := ex.or(it)
+		if err == nil {
+			err = ex.expect(")")
+		}
+		return ok, err
+	}
+	switch fn := ex.peek(); fn {
+	case "attribute_exists", "attribute_not_exists", "begins_with", "contains":
+		ex.next()
+		if err := ex.expect("("); err != nil {
+			return false, err
+		}
+		v := ex.operand(it)
+		var arg *dynamodb.AttributeValue
+		if fn == "begins_with" || fn == "contains" {
+			if err := ex.expect(","); err != nil {
+				return false, err
+			}
+			arg = ex.operand(it)
+		}
+		if err := ex.expect(")"); err != nil {
+			return false, err
+		}
+		switch fn {
+		case "attribute_exists":
+			return v != nil, nil
+		case "attribute_not_exists":
+			return v == nil, nil
+		case "begins_with":
+			return v != nil && v.S != nil && strings.HasPrefix(*v.S, aws.StringValue(arg.S)), nil
+		default:
+			return v != nil && (strings.Contains(aws.StringValue(v.S), aws.StringValue(arg.S)) ||
+				contains(v.SS, aws.StringValue(arg.S))), nil
+		}
+	}
+	left := ex.operand(it)
+	op := ex.next()
+	if strings.EqualFold(op, "BETWEEN") {
+		low := ex.operand(it)
+		if err := ex.expect("AND"); err != nil {
+			return false, err
+		}
+		high := ex.operand(it)
+		return compare(left, low) >= 0 && compare(left, high) <= 0 && left != nil, nil
+	}
+	right := ex.operand(it)
+	if left == nil || right == nil {
+		return op == "<>" && (left != nil || right != nil), nil
+	}
+	c := compare(left, right)
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %q in %v", op, ex.tokens)
+}
+
+// operand reads a value placeholder or a path, nil if the path is missing
+func (ex *exprContext) operand(it item) *dynamodb.AttributeValue {
+	t := ex.peek()
+	if strings.HasPrefix(t, ":") {
+		ex.next()
+		return ex.values[t]
+	}
+	return lookup(it, ex.path())
+}
+
+// path reads a document path like "#0.#1" or "secret.salt"
+func (ex *exprContext) path() []string {
+	path := []string{ex.name(ex.next())}
+	for ex.peek() == "." {
+		ex.next()
+		path = append(path, ex.name(ex.next()))
+	}
+	return path
+}
+
+func (ex *exprContext) name(t string) string {
+	if strings.HasPrefix(t, "#") {
+		return aws.StringValue(ex.names[t])
+	}
+	return t
+}
+
+func lookup(it item, path []string) *dynamodb.AttributeValue {
+	v := it[path[0]]
+	for _, name := range path[1:] {
+		if v == nil || v.M == nil {
+			return nil
+		}
+		v = v.M[name]
+	}
+	return v
+}
+
+// set writes v at path, creating no intermediate maps, a nil v removes it
+func set(it item, path []string, v *dynamodb.AttributeValue) {
+	m := it
+	for _, name := range path[:len(path)-1] {
+		parent := m[name]
+		if parent == nil || parent.M == nil {
+			return
+		}
+		// copy on write, items share their values with earlier reads
+		copied := &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{}}
+		for k, child := range parent.M {
+			copied.M[k] = child
+		}
+		m[name] = copied
+		m = copied.M
+	}
+	if v == nil {
+		delete(m, path[len(path)-1])
+		return
+	}
+	m[path[len(path)-1]] = v
+}
+
+func compare(a, b *dynamodb.AttributeValue) int {
+	if a == nil || b == nil {
+		return -1
+	}
+	if a.N != nil && b.N != nil {
+		x, _ := strconv.ParseFloat(*a.N, 64)
+		y, _ := strconv.ParseFloat(*b.N, 64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	if a.BOOL != nil && b.BOOL != nil {
+		if *a.BOOL == *b.BOOL {
+			return 0
+		}
+		return -1
+	}
+	return strings.Compare(aws.StringValue(a.S), aws.StringValue(b.S))
+}
+
+func contains(list []*string, s string) bool {
+	for _, v := range list {
+		if aws.StringValue(v) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// project keeps the top level attributes of the projection, all without one
+func (ex exprContext) project(projection string, it item) item {
+	if projection == "" {
+		return copyItem(it)
+	}
+	projected := item{}
+	for _, name := range strings.Split(projection, ",") {
+		name = ex.name(strings.TrimSpace(name))
+		if v, ok := it[name]; ok {
+			projected[name] = v
+		}
+	}
+	return projected
+}
+
+// update applies an update expression of SET, REMOVE, ADD and DELETE
+// clauses to it
+func (ex exprContext) update(expr string, it item) error {
+	ex.start(expr)
+	clause := ""
+	for ex.peek() != "" {
+		switch t := strings.ToUpper(ex.peek()); t {
+		case "SET", "REMOVE", "ADD", "DELETE":
+			clause = t
+			ex.next()
+			continue
+		case ",":
+			ex.next()
+			continue
+		}
+		path := ex.path()
+		switch clause {
+		case "SET":
+			if err := ex.expect("="); err != nil {
+				return err
+			}
+			v := ex.operand(it)
+			if op := ex.peek(); op == "+" || op == "-" {
+				ex.next()
+				v = arithmetic(v, ex.operand(it), op)
+			}
+			set(it, path, v)
+		case "REMOVE":
+			set(it, path, nil)
+		case "ADD":
+			current, v := lookup(it, path), ex.operand(it)
+			switch {
+			case v.N != nil:
+				if current == nil {
+					current = &dynamodb.AttributeValue{N: aws.String("0")}
+				}
+				set(it, path, arithmetic(current, v, "+"))
+			case v.SS != nil:
+				union := []*string{}
+				if current != nil {
+					union = append(union, current.SS...)
+				}
+				for _, s := range v.SS {
+					if !contains(union, *s) {
+						union = append(union, s)
+					}
+				}
+				set(it, path, &dynamodb.AttributeValue{SS: union})
+			}
+		case "DELETE":
+			current, v := lookup(it, path), ex.operand(it)
+			if current == nil {
+				continue
+			}
+			rest := []*string{}
+			for _, s := range current.SS {
+				if !contains(v.SS, *s) {
+					rest = append(rest, s)
+				}
+			}
+			if len(rest) == 0 {
+				set(it, path, nil)
+			} else {
+				set(it, path, &dynamodb.AttributeValue{SS: rest})
+			}
+		default:
+			return fmt.Errorf("unsupported update %q", expr)
+		}
+	}
+	return nil
+}
+
+func arithmetic(a, b *dynamodb.AttributeValue, op string) *dynamodb.AttributeValue {
+	x, _ := strconv.ParseInt(aws.StringValue(a.N), 10, 64)
+	y, _ := strconv.ParseInt(aws.StringValue(b.N), 10, 64)
+	if op == "-" {
+		y = -y
+	}
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(x+y, 10))}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// GroupJSON creates or updates a group
+type GroupJSON struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func (g GroupJSON) Validate() error {
+	return validation.ValidateStruct(&g,
+		validation.Field(&g.Name, validation.Required, validation.Match(schema.GroupNameRegexp)),
+		validation.Field(&g.Description, validation.Length(0, 256)),
+	)
+}
+
+// MemberJSON adds a user to a group
+type MemberJSON struct {
+	UserName string `json:"user_name,omitempty"`
+}
+
+// writeStoreError writes the response of a failed store call
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch err {
+	case dynamo.ErrNotFound:
+		http.Error(w, "not found", http.StatusNotFound)
+	case dynamo.ErrExists:
+		http.Error(w, "already exists", http.StatusConflict)
+	case dynamo.ErrBadCursor:
+		http.Error(w, "bad cursor", http.StatusBadRequest)
+	case dynamo.ErrConflict:
+		writeError(w, http.StatusConflict, codeUserChanged, "The account changed meanwhile, try again")
+	default:
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+func createGroupHandler(w http.ResponseWriter, r *http.Request) {
+	req := GroupJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		glog.Warningf("Failed to decode json: %v.", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = req.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+	group := schema.NewGroup(req.Name, req.Description)
+	err = store(r).CreateGroup(group)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, group)
+}
+
+func listGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, limit := pageParams(r)
+	groups, next, err := store(r).ListGroups(cursor, limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, PageJSON{Items: groups, Cursor: next})
+}
+
+func getGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group, err := store(r).GetGroup(mux.Vars(r)["group"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, group)
+}
+
+func updateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	req := GroupJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		glog.Warningf("Failed to decode json: %v.", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req.Name = mux.Vars(r)["group"]
+	err = req.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+	group, err := store(r).GetGroup(req.Name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	group.Description = req.Description
+	err = store(r).UpdateGroup(group)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, group)
+}
+
+func deleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	_, err := store(r).GetGroup(name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	err = store(r).DeleteGroup(name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("User %s deleted group %s", caller(r).UserName, name)
+}
+
+func addMemberHandler(w http.ResponseWriter, r *http.Request) {
+	req := MemberJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.UserName == "" {
+		http.Error(w, "bad request, needs usename", http.StatusBadRequest)
+		return
+	}
+	err = store(r).AddMember(mux.Vars(r)["group"], userKey(r, req.UserName))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func removeMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := store(r).RemoveMember(vars["group"], userKey(r, vars["name"]))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func listMembersHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	_, err := store(r).GetGroup(name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	cursor, limit := pageParams(r)
+	members, next, err := store(r).ListMembers(name, cursor, limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, PageJSON{Items: members, Cursor: next})
+}
+
+// userGroupsHandler lists the groups of a user, the cursor is the offset in
+// the user's groups
+func userGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	dbUser, err := store(r).GetUser(mux.Vars(r)["name"], false)
+	if err != nil {
+		writeUserNotFound(w, r, mux.Vars(r)["name"], http.StatusNotFound, "user not found")
+		return
+	}
+	cursor, limit := pageParams(r)
+	offset := 0
+	if cursor != "" {
+		offset, err = strconv.Atoi(cursor)
+		if err != nil || offset < 0 {
+			http.Error(w, "bad cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	groups := dbUser.Groups
+	if offset > len(groups) {
+		offset = len(groups)
+	}
+	end := offset + limit
+	next := ""
+	if end < len(groups) {
+		next = strconv.Itoa(end)
+	} else {
+		end = len(groups)
+	}
+	writeJSON(w, PageJSON{Items: groups[offset:end], Cursor: next})
+}
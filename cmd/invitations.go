@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/golang/glog"
+)
+
+// InviteJSON invites the owner of an email, optionally with a role
+type InviteJSON struct {
+	Email  string `json:"email,omitempty"`
+	Role   string `json:"role,omitempty"`
+	Locale string `json:"locale,omitempty"`
+}
+
+func (inv InviteJSON) Validate() error {
+	return validation.ValidateStruct(&inv,
+		validation.Field(&inv.Email, validation.Required, is.Email),
+		validation.Field(&inv.Locale, validation.Match(localeRegexp)),
+	)
+}
+
+// inviteOnly returns true if registration needs an invitation in the
+// tenant of the request
+func inviteOnly(r *http.Request) bool {
+	tenant := tenantOf(r)
+	return *inviteOnlyFlag || (tenant != nil && tenant.Settings.InviteOnly)
+}
+
+// inviteHandler sends an invitation. Callers with the invitations:create
+// permission invite freely, others use their invite quota. Only callers
+// managing roles can attach a role.
+func inviteHandler(w http.ResponseWriter, r *http.Request) {
+	req := InviteJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		glog.Warningf("Failed to decode json: %v.", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = req.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+	inviter := caller(r)
+	if req.Role != "" && (!inviter.HasPermission(schema.PermRolesManage) || !schema.ValidRole(req.Role)) {
+		http.Error(w, "can not invite with this role", http.StatusForbidden)
+		return
+	}
+	useQuota := !inviter.HasPermission(schema.PermInvitationsCreate)
+	if useQuota && inviter.InviteQuota <= 0 {
+		http.Error(w, "no invitation left", http.StatusForbidden)
+		return
+	}
+
+	invitation, token := schema.NewInvitation(req.Email, req.Role, inviter.UserName, *inviteExpiry)
+	query := url.Values{}
+	query.Set("invite_token", token)
+	note := verify.VerifyRequest{
+		Type:   verify.TypeInvitation,
+		To:     req.Email,
+		Locale: req.Locale,
+		Detail: inviter.UserName,
+		Link:   apiURL(r) + "/user/register?" + query.Encode(),
+	}
+	err = store(r).CreateInvitation(invitation, useQuota, note)
+	if err == dynamo.ErrNoQuota {
+		http.Error(w, "no invitation left", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("User %s invited %s", inviter.UserName, req.Email)
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+// serverKey is the secret of the server, read from $MUSER_SECRET_KEY as 64
+// hex digits. Every instance must share it: it keys the hashes of the
+// verification codes and seals the secrets of the outbox.
+func serverKey() ([]byte, error) {
+	env := os.Getenv("MUSER_SECRET_KEY")
+	if env == "" {
+		glog.Warningf("No $MUSER_SECRET_KEY, using a random key: pending codes and notifications won't survive a restart")
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		return key, err
+	}
+	key, err := hex.DecodeString(env)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("$MUSER_SECRET_KEY must be 64 hex digits")
+	}
+	return key, nil
+}
+
+// deriveKey derives the key of one use from the server key
+func deriveKey(key []byte, use string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(use))
+	return mac.Sum(nil)
+}
+
+// setupKeys keys the verification codes and returns the outbox sealer
+func setupKeys() (*verify.Sealer, error) {
+	key, err := serverKey()
+	if err != nil {
+		return nil, err
+	}
+	schema.SetCodeKey(deriveKey(key, "verify code"))
+	return verify.NewSealer(deriveKey(key, "outbox"))
+}
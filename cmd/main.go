@@ -4,13 +4,19 @@ import (
 	"encoding/json"
 	"flag"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 
+	"github.com/codemk8/muser/pkg/blob"
 	dynamo "github.com/codemk8/muser/pkg/dynamodb"
 	"github.com/codemk8/muser/pkg/schema"
 	"github.com/codemk8/muser/pkg/verify"
+	"github.com/codemk8/muser/pkg/webhook"
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 	"github.com/gorilla/mux"
@@ -21,8 +27,56 @@ var ip = flag.String("addr", "127.0.0.1:8000", "Serving host and port")
 var table = flag.String("table", "dev.muser.codemk8", "Table name")
 var region = flag.String("region", "us-west-2", "AWS Region the table is in")
 var apiRoot = flag.String("api_root", "/v1", "api root path")
+var apiV2Root = flag.String("api_v2_root", "/v2", "root path of the v2 api")
 var emailEndpoint = flag.String("emailep", "", "Email service for verification")
+var notifierKind = flag.String("notifier", "http", "How notifications are delivered: http (to --emailep), smtp or log")
+var smtpHost = flag.String("smtp_host", "", "SMTP server for the smtp notifier")
+var smtpPort = flag.Int("smtp_port", 587, "SMTP server port")
+var smtpUser = flag.String("smtp_user", "", "SMTP user name, the password is read from $MUSER_SMTP_PASSWORD")
+var smtpFrom = flag.String("smtp_from", "", "From address of notification emails")
+var smtpNoTLS = flag.Bool("smtp_notls", false, "Allow SMTP servers without STARTTLS, for local testing only")
+var smtpTimeout = flag.Duration("smtp_timeout", verify.DefaultSMTPTimeout, "Time the delivery of a mail may take, from connecting to the SMTP server to its answer")
+var outboxInterval = flag.Duration("outbox_interval", 5*time.Second, "How often the outbox of pending notifications is polled")
+var outboxAttempts = flag.Int("outbox_attempts", 10, "Deliveries tried before a notification is given up")
+var notifyLog = flag.String("notify_log", "", "File the log notifier appends to, defaults to the server log")
+var templateDir = flag.String("template_dir", "", "Directory of <locale>/<type>.subject|txt|html files overriding the built-in notification templates")
+var fallbackLocales = flag.String("fallback_locales", "en", "Comma separated locales tried when the user's locale has no template")
+var publicURL = flag.String("public_url", "", "Base url used in links sent by email, defaults to http://<addr>")
+var tenantMode = flag.String("tenant_mode", "", "How the tenant of a request is resolved: empty for a single tenant, host (the tenant's hosts or <tenant>.<tenant_domain>) or path (<api_root>/t/<tenant>/...)")
+var tenantDomain = flag.String("tenant_domain", "", "Base domain of the tenants in host mode, <tenant>.<tenant_domain> is the host of a tenant listing none")
+var revertPeriod = flag.Duration("revert_period", 7*24*time.Hour, "How long the previous address can revert an email change")
+var inviteOnlyFlag = flag.Bool("invite_only", false, "Require an invitation to register, tenants can also turn it on in their settings")
+var inviteExpiry = flag.Duration("invite_expiry", 7*24*time.Hour, "How long an invitation can be used")
+var inviteQuota = flag.Int("invite_quota", 0, "Invitations every new user can send")
+var resetExpiry = flag.Duration("reset_expiry", 24*time.Hour, "How long the link of a password reset forced by an admin can be used")
+var deleteGrace = flag.Duration("delete_grace", 30*24*time.Hour, "How long users can restore their deleted account before it is purged")
+var purgeInterval = flag.Duration("purge_interval", time.Hour, "How often deleted accounts past --delete_grace are purged")
+var deletedNames = flag.String("deleted_names", "free", "What happens to the name of a purged account: free or reserve")
+var reservePeriod = flag.Duration("reserve_period", 0, "How long the name of a purged account is reserved with --deleted_names=reserve, 0 is forever")
+var maxFailedLogins = flag.Int("max_failed_logins", 5, "Consecutive wrong passwords locking an account, 0 never locks")
+var lockoutPeriod = flag.Duration("lockout_period", 15*time.Minute, "How long an account stays locked after too many wrong passwords")
+var requireVerifiedFlag = flag.Bool("require_verified", false, "Require a verified email to authenticate, tenants can also turn it on in their settings")
+var verifyGrace = flag.Duration("verify_grace", 24*time.Hour, "How long new users can authenticate before verifying their email with --require_verified")
+var registrationEmail = flag.String("registration_email", "optional", "Email at registration: none, optional or required, it is verified with a code sent at once")
+var blacklistFile = flag.String("blacklist_file", "", "File of reserved user names and patterns, one per line, reloaded when modified")
+var blacklistReload = flag.Duration("blacklist_reload", 30*time.Second, "How often the blacklist file and the blacklist entries of the store are reloaded")
+var renameCooldown = flag.Duration("rename_cooldown", 90*24*time.Hour, "How long the old name of a renamed user is reserved and redirects to the new one")
+var profileAttributesFile = flag.String("profile_attributes", "", "Json file declaring custom profile attributes: [{\"name\", \"type\", \"required\", \"max_length\", \"pattern\", \"visibility\"}]")
+var avatarStore = flag.String("avatar_store", "local", "Blob store of avatars, local keeps them in --avatar_dir")
+var avatarDirFlag = flag.String("avatar_dir", "avatars", "Directory of the local avatar store")
+var avatarURL = flag.String("avatar_url", "", "Base URL of avatars, e.g. of a CDN, by default the local store is served under <api_root>/avatars")
+var avatarMaxBytes = flag.Int64("avatar_max_bytes", 5<<20, "Largest avatar upload in bytes")
+var avatarSizesFlag = flag.String("avatar_sizes", "256,128,64", "Comma separated sizes in pixels of the square avatar thumbnails")
+var avatarDecodersFlag = flag.Int("avatar_decoders", 2, "Most avatar uploads decoded at once, the others wait")
+var resendCooldown = flag.Duration("resend_cooldown", time.Minute, "Minimum wait before resending a verification code")
+var webhookConfig = flag.String("webhooks", "", "Json file listing webhook subscriptions: [{\"url\", \"secret\", \"events\"}]")
+var webhookAttempts = flag.Int("webhook_attempts", 5, "Deliveries tried before a webhook event becomes a dead letter")
+var webhookBackoff = flag.Duration("webhook_backoff", time.Second, "Wait before the first webhook retry, doubled on every retry")
+var adminUser = flag.String("admin_user", "admin", "Admin created on first start, the password is read from $MUSER_ADMIN_PASSWORD")
+var promoteExisting = flag.Bool("promote_existing", false, "Make an existing user named --admin_user the admin on first start, keeping its password, instead of failing")
+var adminPasswordFile = flag.String("admin_password_file", "admin_password", "File, readable by the owner only, the generated password of the admin is written to without $MUSER_ADMIN_PASSWORD")
 var client *dynamo.DynamoClient
+var webhooks *webhook.Dispatcher
 
 // HashPassword encrypts password into bcrypt hash, the cost should be at least 12
 func HashPassword(password string) (string, error) {
@@ -41,6 +95,31 @@ func CheckPasswordHash(password, hash string) bool {
 type UserJSON struct {
 	UserName string `json:"user_name,omitempty"`
 	Password string `json:"password,omitempty"`
+	// see --registration_email
+	Email  string `json:"email,omitempty"`
+	Locale string `json:"locale,omitempty"`
+	// required to register when registration is invite only
+	InviteToken string `json:"invite_token,omitempty"`
+	// custom attributes, see --profile_attributes
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// AccountJSON identifies a user in responses, the ID never changes
+type AccountJSON struct {
+	ID          string `json:"id,omitempty"`
+	UserName    string `json:"user_name"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+func accountOf(user *schema.User) AccountJSON {
+	return AccountJSON{ID: user.ID, UserName: user.UserName, DisplayName: user.DisplayName}
+}
+
+// VerifiedJSON answers a verification with the verified email
+type VerifiedJSON struct {
+	UserName string `json:"user_name"`
+	Email    string `json:"email,omitempty"`
+	Verified bool   `json:"verified"`
 }
 
 // Veirfy is request for (email) verification
@@ -54,6 +133,8 @@ func (a UserJSON) Validate() error {
 	return validation.ValidateStruct(&a,
 		validation.Field(&a.UserName, validation.Required, validation.Length(5, 32)),
 		validation.Field(&a.Password, validation.Required, validation.Length(7, 32)),
+		validation.Field(&a.Email, is.Email),
+		validation.Field(&a.Locale, validation.Match(localeRegexp)),
 	)
 }
 
@@ -62,20 +143,35 @@ type UpdateUserJSON struct {
 	UserName    string `json:"user_name,omitempty"`
 	Email       string `json:"email,omitempty"`
 	Avatar      string `json:"avatar,omitempty"`
+	Locale      string `json:"locale,omitempty"`
 	Password    string `json:"password,omitempty"`
 	NewPassword string `json:"new_password,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	// custom attributes to set, null removes one
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
+var localeRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
+// Validate checks the update, the current password is always required
 func (update UpdateUserJSON) Validate() error {
-	if update.Password != "" {
-		return validation.ValidateStruct(&update,
-			validation.Field(&update.NewPassword, validation.Required, validation.Length(7, 32)))
-	}
-	if update.Email != "" {
-		return validation.ValidateStruct(&update,
-			validation.Field(&update.Email, validation.Required, is.Email))
+	return validation.ValidateStruct(&update,
+		validation.Field(&update.Password, validation.Required),
+		validation.Field(&update.NewPassword, validation.Length(7, 32)),
+		validation.Field(&update.Email, is.Email),
+		validation.Field(&update.Locale, validation.Match(localeRegexp)),
+		validation.Field(&update.Timezone, validation.By(validTimezone)))
+}
+
+// invitePageHandler answers the link of an invitation with a page
+// registering with its token
+func invitePageHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("invite_token")
+	if token == "" {
+		http.Error(w, "bad request, needs invite_token", http.StatusBadRequest)
+		return
 	}
-	return nil
+	writePage(w, "invite", pageData{Token: token, Action: r.URL.Path})
 }
 
 func registerHandler(w http.ResponseWriter, r *http.Request) {
@@ -95,15 +191,61 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if client.BadUserName(user.UserName) {
+	err = attributeSchema(r).ValidateValues(user.Attributes)
+	if err != nil {
+		b, _ := json.Marshal(validation.Errors{"attributes": err})
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+
+	tenant := tenantOf(r)
+	if tenant != nil && tenant.Settings.DisableRegistration {
+		http.Error(w, "registration is disabled", http.StatusForbidden)
+		return
+	}
+
+	if user.InviteToken == "" {
+		user.InviteToken = r.URL.Query().Get("invite_token")
+	}
+	if user.Email == "" && *registrationEmail == "required" && user.InviteToken == "" {
+		http.Error(w, "bad request, an email is required", http.StatusBadRequest)
+		return
+	}
+	if user.Email != "" && *registrationEmail == "none" {
+		http.Error(w, "bad request, email can only be set once registered", http.StatusBadRequest)
+		return
+	}
+	var invitation *schema.Invitation
+	if user.InviteToken != "" {
+		invitation, err = store(r).GetInvitation(schema.HashToken(user.InviteToken))
+		if err != nil || !invitation.Valid() {
+			http.Error(w, "invalid or expired invitation", http.StatusForbidden)
+			return
+		}
+	} else if inviteOnly(r) {
+		http.Error(w, "registration requires an invitation", http.StatusForbidden)
+		return
+	}
+
+	name, err := schema.CanonicalUserName(user.UserName)
+	if err != nil {
+		http.Error(w, "bad request, "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if store(r).BadUserName(name) {
 		glog.Warningf("Username %s is in blacklist", user.UserName)
 		http.Error(w, "username is not available", http.StatusBadRequest)
 		return
 	}
 
-	if client.UserExist(user.UserName) {
+	if store(r).Confusable(name) {
+		http.Error(w, "bad request, "+schema.ErrConfusable.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if store(r).UserExist(name) || store(r).UserExist(user.UserName) || store(r).Reserved(name) {
 		glog.Warningf("User already exist")
-		http.Error(w, "the username already exist", http.StatusBadRequest)
+		http.Error(w, "the username already exist", conflictStatus(r))
 		return
 	}
 
@@ -114,36 +256,51 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbUser := schema.NewUser(user.UserName, hash)
-	err = client.AddNewUser(dbUser)
-	if err != nil {
-		glog.Warningf("Error adding new user: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	dbUser := schema.NewUser(name, hash)
+	dbUser.DisplayName = user.UserName
+	dbUser.InviteQuota = *inviteQuota
+	dbUser.Profile.Locale = user.Locale
+	dbUser.Profile.Attributes = user.Attributes
+	if invitation != nil {
+		// the invitee proved owning the email by following the link
+		dbUser.Profile.Email = invitation.Email
+		dbUser.Profile.Verified = true
+		if invitation.Role != "" {
+			dbUser.Roles = []string{invitation.Role}
+		}
+		err = store(r).RegisterInvited(dbUser, invitation.TokenHash)
+	} else if user.Email != "" {
+		// the email is claimed once verified with the code sent
+		dbUser.Profile.Email = user.Email
+		err = store(r).RegisterUser(dbUser, newVerifyCode(dbUser))
+	} else {
+		err = store(r).RegisterUser(dbUser)
+	}
+	if err == dynamo.ErrExists {
+		http.Error(w, "the username already exist or the invitation was used", conflictStatus(r))
 		return
 	}
-	return
-}
-
-func authHandler(w http.ResponseWriter, r *http.Request) {
-	username, password, authOK := r.BasicAuth()
-	if authOK == false {
-		glog.Warning("Failed to parse basic auth from header")
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
+	if err == dynamo.ErrEmailTaken {
+		writeError(w, http.StatusConflict, codeEmailTaken, "The email belongs to another account")
 		return
 	}
-	user, err := client.GetUser(username, true)
 	if err != nil {
-		glog.Warningf("Failed to get user from db: %v.", err)
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		glog.Warningf("Error adding new user: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
-	match := CheckPasswordHash(password, user.Secret.Salt)
-	if !match {
-		glog.Warning("Password does not match hash.")
-		http.Error(w, "Wrong user name or password", http.StatusUnauthorized)
+	webhooks.Publish(webhook.UserRegistered, store(r).Tenant(), dbUser.UserName, nil)
+	if isV2(r) {
+		writeCreated(w, r, dbUser.UserName, selfView(dbUser))
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, accountOf(dbUser))
+}
+
+// authHandler answers the account of callers with valid credentials, it is
+// routed through requirePermission
+func authHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, accountOf(caller(r)))
 }
 
 func updateHandler(w http.ResponseWriter, r *http.Request) {
@@ -159,9 +316,9 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbUser, err := client.GetUser(update.UserName, true)
+	dbUser, err := store(r).GetUser(update.UserName, true)
 	if err != nil {
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		writeInvalidCredentials(w)
 		return
 	}
 	if dbUser == nil {
@@ -177,45 +334,82 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if update.Password != "" {
-		match := CheckPasswordHash(update.Password, dbUser.Secret.Salt)
-		if !match {
-			http.Error(w, "Invalid user name or password", http.StatusUnauthorized)
-			return
-		}
+	if update.Avatar != "" {
+		http.Error(w, "bad request, upload avatars to /user/avatar", http.StatusBadRequest)
+		return
+	}
+
+	if !checkPassword(w, r, dbUser, update.Password) {
+		return
+	}
+	if !selfService(dbUser) {
+		writeStatusError(w, dbUser)
+		return
+	}
+	applyUpdate(w, r, dbUser, update)
+}
+
+// applyUpdate changes the user as the validated update of a caller with
+// the user's credentials asks, it writes the error response and returns
+// false if it fails
+func applyUpdate(w http.ResponseWriter, r *http.Request, dbUser *schema.User, update UpdateUserJSON) bool {
+	// notifications are stored with the user and delivered by the outbox worker
+	notes := []verify.VerifyRequest{}
+	if update.NewPassword != "" {
 		newHash, err := HashPassword(update.NewPassword)
 		if err != nil {
 			http.Error(w, "Internal error", http.StatusInternalServerError)
-			return
+			return false
 		}
 		dbUser.Secret.Salt = newHash
-
-	} else {
-		if update.Email != "" {
-			// TODO check duplicated email, reject if same email found
-
-			dbUser.Profile.Email = update.Email
-			// generate code here
-			code, expiry := schema.GenVerifyCodeAndExpiry(60)
-			dbUser.Secret.VerifyCode = code
-			dbUser.Secret.CodeExpiry = expiry
-			dbUser.Profile.Verified = false
-			// send notification to email service to send the verify code
-			verify.SendVerifyEmail(*emailEndpoint, update.UserName, update.Email, code)
+		if dbUser.Profile.Verified {
+			notes = append(notes, verify.VerifyRequest{
+				Type:     verify.TypeSecurityAlert,
+				UserName: dbUser.UserName,
+				To:       dbUser.Profile.Email,
+				Locale:   dbUser.Profile.Locale,
+				Event:    verify.EventPasswordChanged,
+			})
+		}
+	}
+	if update.Email != "" && update.Email != dbUser.Profile.Email {
+		// claimed once verified, checked now to fail early
+		owner, err := store(r).EmailOwner(update.Email)
+		if err == nil && owner != dbUser.UserName {
+			writeError(w, http.StatusConflict, codeEmailTaken, "The email belongs to another account")
+			return false
 		}
-		if update.Avatar != "" {
-			// TODO check format
-			dbUser.Profile.Avatar = update.Avatar
+		// the new email only replaces the current one once verified
+		dbUser.Secret.PendingEmail = update.Email
+		notes = append(notes, newVerifyCode(dbUser))
+	}
+	if update.Locale != "" {
+		dbUser.Profile.Locale = update.Locale
+	}
+	if update.Timezone != "" {
+		dbUser.Profile.Timezone = update.Timezone
+	}
+	if update.Attributes != nil {
+		attributes := attributeSchema(r)
+		merged := attributes.Merge(dbUser.Profile.Attributes, update.Attributes)
+		err := attributes.ValidateValues(merged)
+		if err != nil {
+			b, _ := json.Marshal(validation.Errors{"attributes": err})
+			http.Error(w, string(b), http.StatusBadRequest)
+			return false
 		}
+		dbUser.Profile.Attributes = merged
 	}
-	err = client.AddNewUser(dbUser)
+	err := store(r).SaveUser(dbUser, notes...)
 	if err != nil {
-		glog.Warningf("Error adding new user: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-		return
+		glog.Warningf("Error updating user: %v", err)
+		writeStoreError(w, err)
+		return false
 	}
-
-	return
+	if update.NewPassword != "" {
+		webhooks.Publish(webhook.UserPasswordChanged, store(r).Tenant(), dbUser.UserName, nil)
+	}
+	return true
 }
 
 func getHandler(w http.ResponseWriter, r *http.Request) {
@@ -231,64 +425,361 @@ func getHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request, needs usename", http.StatusBadRequest)
 		return
 	}
-	dbUser, err := client.GetUser(user.UserName, false)
+	writeUser(w, r, user.UserName)
+}
+
+// writeUser answers the view of the user named name for the caller
+func writeUser(w http.ResponseWriter, r *http.Request, name string) {
+	// the secret is read for the self and admin views
+	dbUser, err := store(r).GetUser(name, true)
+	if err != nil || !visible(r, dbUser) {
+		writeUserNotFound(w, r, name, http.StatusNotFound, "user not found")
+		return
+	}
+	err = store(r).EnsureUserID(dbUser)
 	if err != nil {
-		glog.Warningf("Failed to get user from db: %v.", err)
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		glog.Warningf("Failed to assign an id to %s: %v", dbUser.UserName, err)
+	}
+	writeJSON(w, userView(r, dbUser, caller(r)))
+}
+
+// getByIDHandler returns the user with the ID in the path, which other
+// services keep to refer to users whatever their names become
+func getByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !schema.ValidUserID(id) {
+		http.Error(w, "bad request, invalid id", http.StatusBadRequest)
 		return
 	}
-	userJSON, err := json.Marshal(dbUser)
+	writeUserByID(w, r, id)
+}
+
+// writeUserByID answers the view of the user with the ID for the caller
+func writeUserByID(w http.ResponseWriter, r *http.Request, id string) {
+	dbUser, err := store(r).GetUserByID(id, true)
+	if err == nil && !visible(r, dbUser) {
+		err = dynamo.ErrNotFound
+	}
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeStoreError(w, err)
 		return
 	}
-	w.Write(userJSON)
+	writeJSON(w, userView(r, dbUser, caller(r)))
+}
 
+// promotePendingEmail makes the pending email of the user its email, the
+// previous email is told with a link to revert the change. While a revert
+// is open, further changes keep it: whoever took over the account can't
+// replace the owner's link by changing the email again.
+func promotePendingEmail(r *http.Request, dbUser *schema.User) []verify.VerifyRequest {
+	notes := []verify.VerifyRequest{}
+	if dbUser.Secret.PendingEmail == "" {
+		return notes
+	}
+	oldEmail := dbUser.Profile.Email
+	oldVerified := dbUser.Profile.Verified
+	dbUser.Profile.Email = dbUser.Secret.PendingEmail
+	dbUser.Secret.PendingEmail = ""
+	if oldEmail == "" {
+		return notes
+	}
+	note := verify.VerifyRequest{
+		Type:     verify.TypeEmailChanged,
+		UserName: dbUser.UserName,
+		To:       oldEmail,
+		Locale:   dbUser.Profile.Locale,
+		NewEmail: dbUser.Profile.Email,
+	}
+	if !dbUser.Secret.RevertOpen(time.Now().Unix()) {
+		token := dbUser.Secret.SetRevert(oldEmail, oldVerified, *revertPeriod)
+		note.RevertLink = revertLink(r, dbUser.UserName, token)
+	}
+	return append(notes, note)
 }
 
 func verifyHandler(w http.ResponseWriter, r *http.Request) {
-	verify := VerifyJSON{}
-	err := json.NewDecoder(r.Body).Decode(&verify)
+	verifyReq := VerifyJSON{}
+	err := json.NewDecoder(r.Body).Decode(&verifyReq)
 	if err != nil {
 		glog.Warningf("Failed to decode json: %v.", err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	if verify.UserName == "" || verify.VerifyCode == "" {
+	verifyReq.UserName = pathName(r, verifyReq.UserName)
+	if verifyReq.UserName == "" || verifyReq.VerifyCode == "" {
 		glog.Warningf("No user name or verify code in verify handler")
 		http.Error(w, "bad request, needs usename or verifying code", http.StatusBadRequest)
 		return
 	}
-	dbUser, err := client.GetUser(verify.UserName, true)
+	dbUser, err := store(r).GetUser(verifyReq.UserName, true)
 	if err != nil {
-		http.Error(w, "not authorized", http.StatusUnauthorized)
+		http.Error(w, "not authorized", missingStatus(r, http.StatusUnauthorized))
 		return
 	}
 	if dbUser == nil {
 		http.Error(w, "user not found", http.StatusBadRequest)
 		return
 	}
+	if dbUser.Secret.VerifyCode == "" {
+		http.Error(w, "no pending verification code", http.StatusBadRequest)
+		return
+	}
 	now := time.Now().Local().Unix()
 	if now >= dbUser.Secret.CodeExpiry {
 		http.Error(w, "verification code expired", http.StatusBadRequest)
 		return
 	}
-	if verify.VerifyCode != dbUser.Secret.VerifyCode {
+	// counted before the check, parallel guesses get no more attempts
+	err = store(r).CountVerifyAttempt(dbUser)
+	if err == dynamo.ErrTooManyAttempts {
+		glog.Warningf("Too many verification attempts for %s", dbUser.UserName)
+		http.Error(w, "too many wrong codes, request a new code later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !dbUser.Secret.CheckVerifyCode(dbUser.UserName, verifyReq.VerifyCode) {
 		http.Error(w, "incorrect verification code", http.StatusBadRequest)
 		return
 	}
+	if !selfService(dbUser) {
+		// only told once the code proved the caller owns the email
+		writeStatusError(w, dbUser)
+		return
+	}
+	dbUser.Secret.ClearVerifyCode()
+	dbUser.Secret.ClearVerifyAttempts()
+	if dbUser.Status == schema.StatusPendingVerification {
+		dbUser.Status = schema.StatusActive
+		dbUser.StatusReason = ""
+		dbUser.StatusChanged = time.Now().Unix()
+	}
+	emailChanged := dbUser.Secret.PendingEmail != ""
+	notes := promotePendingEmail(r, dbUser)
 	dbUser.Profile.Verified = true
-	err = client.AddNewUser(dbUser)
+	// the email is claimed now that it is verified, the previous one stays
+	// claimed so the change can be reverted
+	err = store(r).ChangeEmail(dbUser, "", notes...)
+	if err == dynamo.ErrEmailTaken {
+		writeError(w, http.StatusConflict, codeEmailTaken, "The email belongs to another account")
+		return
+	}
 	if err != nil {
 		glog.Warningf("Error updating user: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeStoreError(w, err)
+		return
+	}
+	webhooks.Publish(webhook.UserVerified, store(r).Tenant(), dbUser.UserName, map[string]interface{}{"email": dbUser.Profile.Email})
+	if emailChanged {
+		webhooks.Publish(webhook.UserEmailChanged, store(r).Tenant(), dbUser.UserName, map[string]interface{}{"email": dbUser.Profile.Email})
+	}
+	writeJSON(w, VerifiedJSON{UserName: dbUser.UserName, Email: dbUser.Profile.Email, Verified: true})
+}
+
+// resendHandler sends a new verification code, at most once per cooldown period
+func resendHandler(w http.ResponseWriter, r *http.Request) {
+	user := UserJSON{}
+	err := json.NewDecoder(r.Body).Decode(&user)
+	if err != nil {
+		glog.Warningf("Failed to decode json: %v.", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	user.UserName = pathName(r, user.UserName)
+	if user.UserName == "" {
+		http.Error(w, "bad request, needs usename", http.StatusBadRequest)
+		return
+	}
+	dbUser, err := store(r).GetUser(user.UserName, true)
+	if err != nil {
+		http.Error(w, "not authorized", missingStatus(r, http.StatusUnauthorized))
+		return
+	}
+	if dbUser.Secret.PendingEmail == "" && (dbUser.Profile.Email == "" || dbUser.Profile.Verified) {
+		http.Error(w, "nothing to verify", http.StatusBadRequest)
 		return
 	}
-	return
+	next := time.Unix(dbUser.Secret.CodeSent, 0).Add(*resendCooldown)
+	if time.Now().Before(next) {
+		http.Error(w, "please wait before requesting a new code", http.StatusTooManyRequests)
+		return
+	}
+	if dbUser.Secret.VerifyBlocked(time.Now().Unix()) {
+		// a new code must not bring more guesses
+		http.Error(w, "too many wrong codes, request a new code later", http.StatusTooManyRequests)
+		return
+	}
+	err = store(r).SaveUser(dbUser, newVerifyCode(dbUser))
+	if err != nil {
+		glog.Warningf("Error updating user: %v", err)
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, VerifiedJSON{UserName: dbUser.UserName})
+}
+
+// revertPageHandler answers the link sent to the previous address with a
+// page confirming the revert, which the link alone must not do
+func revertPageHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("user_name")
+	token := r.FormValue("token")
+	if username == "" || token == "" {
+		http.Error(w, "bad request, needs usename and token", http.StatusBadRequest)
+		return
+	}
+	writePage(w, "revert", pageData{UserName: username, Token: token, Action: r.URL.Path})
+}
+
+// revertHandler restores the email replaced by the last email change, posted
+// from the page of the link sent to the previous address
+func revertHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("user_name")
+	token := r.FormValue("token")
+	if username == "" || token == "" {
+		http.Error(w, "bad request, needs usename and token", http.StatusBadRequest)
+		return
+	}
+	dbUser, err := getLinkedUser(r, username)
+	if err != nil {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+	if !schema.CheckToken(token, dbUser.Secret.RevertToken) {
+		http.Error(w, "invalid revert token", http.StatusBadRequest)
+		return
+	}
+	if time.Now().Unix() >= dbUser.Secret.RevertExpiry {
+		http.Error(w, "revert token expired", http.StatusBadRequest)
+		return
+	}
+	glog.Warningf("Reverting email change of %s", username)
+	changedEmail := dbUser.Profile.Email
+	dbUser.Profile.Email = dbUser.Secret.RevertEmail
+	dbUser.Profile.Verified = dbUser.Secret.RevertVerified
+	// whoever changed the email may try again, drop anything in flight
+	dbUser.Secret.PendingEmail = ""
+	dbUser.Secret.ClearVerifyCode()
+	dbUser.Secret.ClearRevert()
+	err = store(r).ChangeEmail(dbUser, changedEmail)
+	if err == dynamo.ErrEmailTaken {
+		writeError(w, http.StatusConflict, codeEmailTaken, "The email belongs to another account")
+		return
+	}
+	if err != nil {
+		glog.Warningf("Error updating user: %v", err)
+		writeStoreError(w, err)
+		return
+	}
+	webhooks.Publish(webhook.UserEmailChanged, store(r).Tenant(), dbUser.UserName, map[string]interface{}{"email": dbUser.Profile.Email})
+	if r.PostFormValue("token") != "" {
+		// posted by the page
+		writePage(w, "reverted", pageData{UserName: dbUser.UserName})
+	}
+}
+
+// newVerifyCode sets a fresh verification code on the user and returns the
+// notification sending it to the email waiting for verification
+func newVerifyCode(dbUser *schema.User) verify.VerifyRequest {
+	email := dbUser.Secret.PendingEmail
+	if email == "" {
+		email = dbUser.Profile.Email
+	}
+	code := dbUser.Secret.SetVerifyCode(dbUser.UserName, 60)
+	return verify.VerifyRequest{
+		Type:       verify.TypeVerify,
+		UserName:   dbUser.UserName,
+		To:         email,
+		Locale:     dbUser.Profile.Locale,
+		VerifyCode: code,
+	}
+}
+
+// revertLink builds the link sent to the previous address on email change
+func revertLink(r *http.Request, username string, token string) string {
+	query := url.Values{}
+	query.Set("user_name", username)
+	query.Set("token", token)
+	return apiURL(r) + "/user/email/revert?" + query.Encode()
+}
+
+// userRoutes adds the routes served for every tenant to r
+func userRoutes(r *mux.Router) {
+	r.HandleFunc("/user/register", registerHandler).Methods("POST")
+	r.HandleFunc("/user/register", invitePageHandler).Methods("GET")
+	r.HandleFunc("/user/auth", requirePermission("", authHandler)).Methods("GET")
+	r.HandleFunc("/user/me", requirePermission("", getMeHandler)).Methods("GET")
+	r.HandleFunc("/user/me", requirePermission("", patchMeHandler)).Methods("PATCH")
+	r.HandleFunc("/user/me/password", requirePermission("", changePasswordHandler)).Methods("POST")
+	r.HandleFunc("/user/me/email", requirePermission("", changeEmailHandler)).Methods("POST")
+	r.HandleFunc("/user/update", updateHandler).Methods("POST")
+	r.HandleFunc("/user", allowAnonymous(schema.PermUserRead, getHandler)).Methods("POST")
+	r.HandleFunc("/user/verify", verifyHandler).Methods("POST")
+	r.HandleFunc("/user/verify/resend", resendHandler).Methods("POST")
+	r.HandleFunc("/user/email/revert", revertPageHandler).Methods("GET")
+	r.HandleFunc("/user/email/revert", revertHandler).Methods("POST")
+	r.HandleFunc("/user/password/reset", resetPasswordHandler).Methods("POST")
+	r.HandleFunc("/user/password/reset", resetPageHandler).Methods("GET")
+	r.HandleFunc("/user/delete", deleteAccountHandler).Methods("POST")
+	r.HandleFunc("/user/restore", restoreAccountHandler).Methods("POST")
+	r.HandleFunc("/user/rename", renameHandler).Methods("POST")
+	r.HandleFunc("/user/avatar", requirePermission("", uploadAvatarHandler)).Methods("POST")
+	r.HandleFunc("/user/avatar", requirePermission("", deleteAvatarHandler)).Methods("DELETE")
+	r.HandleFunc("/users/{id}", allowAnonymous(schema.PermUserRead, getByIDHandler)).Methods("GET")
+	r.HandleFunc("/user/{name}/groups", requirePermission(schema.PermGroupsRead, userGroupsHandler)).Methods("GET")
+	r.HandleFunc("/groups", requirePermission(schema.PermGroupsManage, createGroupHandler)).Methods("POST")
+	r.HandleFunc("/groups", requirePermission(schema.PermGroupsRead, listGroupsHandler)).Methods("GET")
+	r.HandleFunc("/groups/{group}", requirePermission(schema.PermGroupsRead, getGroupHandler)).Methods("GET")
+	r.HandleFunc("/groups/{group}", requirePermission(schema.PermGroupsManage, updateGroupHandler)).Methods("POST")
+	r.HandleFunc("/groups/{group}", requirePermission(schema.PermGroupsManage, deleteGroupHandler)).Methods("DELETE")
+	r.HandleFunc("/groups/{group}/members", requirePermission(schema.PermGroupsRead, listMembersHandler)).Methods("GET")
+	r.HandleFunc("/groups/{group}/members", requirePermission(schema.PermGroupsManage, addMemberHandler)).Methods("POST")
+	r.HandleFunc("/groups/{group}/members/{name}", requirePermission(schema.PermGroupsManage, removeMemberHandler)).Methods("DELETE")
+	r.HandleFunc("/invitations", requirePermission("", inviteHandler)).Methods("POST")
+	r.HandleFunc("/roles/grant", requirePermission(schema.PermRolesManage, grantRoleHandler)).Methods("POST")
+	r.HandleFunc("/roles/revoke", requirePermission(schema.PermRolesManage, revokeRoleHandler)).Methods("POST")
+	adminRoutes(r)
+}
+
+// newRouter routes the v1 and v2 apis
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	api := r.PathPrefix(*apiRoot).Subrouter()
+	api.Use(resolveTenant)
+	if *tenantMode == "path" {
+		userRoutes(api.PathPrefix("/t/{tenant}").Subrouter())
+	}
+	userRoutes(api)
+	if *avatarStore == "local" && *avatarURL == "" {
+		api.PathPrefix("/avatars/").Handler(serveAvatars(*apiRoot+"/avatars/", *avatarDirFlag)).Methods("GET", "HEAD")
+	}
+	api.HandleFunc("/tenants", defaultTenantOnly(requirePermission(schema.PermTenantsManage, createTenantHandler))).Methods("POST")
+	api.HandleFunc("/tenants", defaultTenantOnly(requirePermission(schema.PermTenantsManage, listTenantsHandler))).Methods("GET")
+	api.HandleFunc("/tenants/{name}", defaultTenantOnly(requirePermission(schema.PermTenantsManage, getTenantHandler))).Methods("GET")
+	api.HandleFunc("/tenants/{name}", defaultTenantOnly(requirePermission(schema.PermTenantsManage, updateTenantHandler))).Methods("POST")
+	api.HandleFunc("/blacklist", defaultTenantOnly(requirePermission(schema.PermBlacklistManage, listBlacklistHandler))).Methods("GET")
+	api.HandleFunc("/blacklist", defaultTenantOnly(requirePermission(schema.PermBlacklistManage, addBlacklistHandler))).Methods("POST")
+	api.HandleFunc("/blacklist", defaultTenantOnly(requirePermission(schema.PermBlacklistManage, removeBlacklistHandler))).Methods("DELETE")
+	api.HandleFunc("/webhooks/deadletters", defaultTenantOnly(requirePermission(schema.PermWebhooksManage, deadLettersHandler))).Methods("GET")
+	api.HandleFunc("/webhooks/deadletters/{id}/replay", defaultTenantOnly(requirePermission(schema.PermWebhooksManage, replayHandler))).Methods("POST")
+	v2 := r.PathPrefix(*apiV2Root).Subrouter()
+	v2.Use(resolveTenant, apiV2)
+	if *tenantMode == "path" {
+		v2Routes(v2.PathPrefix("/t/{tenant}").Subrouter())
+	}
+	v2Routes(v2)
+	return r
 }
 
 func main() {
 	flag.Parse()
+	if *registrationEmail != "none" && *registrationEmail != "optional" && *registrationEmail != "required" {
+		glog.Fatalf("Unknown --registration_email %s, use none, optional or required", *registrationEmail)
+	}
+	if *deletedNames != "free" && *deletedNames != "reserve" {
+		glog.Fatalf("Unknown --deleted_names %s, use free or reserve", *deletedNames)
+	}
 	var err error
 	glog.Infof("Creating AWS client...\n")
 	client, err = dynamo.NewClient(*table, *region)
@@ -296,13 +787,104 @@ func main() {
 		panic("Failed init dynamoDB, check credentials or table name.")
 	}
 	glog.Infof("Creating AWS client done!\n")
+	sealer, err := setupKeys()
+	if err != nil {
+		glog.Fatalf("Failed to set up the server key: %v", err)
+	}
+	client.SetSealer(sealer)
+	err = client.RedactDeadNotifications()
+	if err != nil {
+		glog.Fatalf("Failed to redact dead notifications: %v", err)
+	}
+	err = migrateUserNames()
+	if err != nil {
+		glog.Fatalf("Failed to migrate user names: %v", err)
+	}
+	err = bootstrapAdmin()
+	if err != nil {
+		glog.Fatalf("Failed to bootstrap the admin user: %v", err)
+	}
 
-	r := mux.NewRouter()
-	r.HandleFunc(*apiRoot+"/user/register", registerHandler).Methods("POST")
-	r.HandleFunc(*apiRoot+"/user/auth", authHandler).Methods("GET")
-	r.HandleFunc(*apiRoot+"/user/update", updateHandler).Methods("POST")
-	r.HandleFunc(*apiRoot+"/user", getHandler).Methods("POST")
-	r.HandleFunc(*apiRoot+"/user/verify", verifyHandler).Methods("POST")
+	notifier, err := verify.NewNotifier(verify.Config{
+		Kind:     *notifierKind,
+		Endpoint: *emailEndpoint,
+		SMTP: verify.SMTPConfig{
+			Host:     *smtpHost,
+			Port:     *smtpPort,
+			UserName: *smtpUser,
+			Password: os.Getenv("MUSER_SMTP_PASSWORD"),
+			From:     *smtpFrom,
+			NoTLS:    *smtpNoTLS,
+			Timeout:  *smtpTimeout,
+		},
+		LogFile:     *notifyLog,
+		TemplateDir: *templateDir,
+		Fallbacks:   strings.Split(*fallbackLocales, ","),
+	})
+	if err != nil {
+		glog.Fatalf("Failed to create notifier: %v", err)
+	}
+	outbox := verify.NewOutboxWorker(client, notifier)
+	outbox.Interval = *outboxInterval
+	outbox.MaxAttempts = *outboxAttempts
+	outbox.Sealer = sealer
+	go outbox.Run(make(chan struct{}))
+
+	if *profileAttributesFile != "" {
+		profileAttributes, err = schema.LoadAttributeSchema(*profileAttributesFile)
+		if err != nil {
+			glog.Fatalf("Failed to load profile attributes: %v", err)
+		}
+	}
+
+	avatarSizes, err = parseSizes(*avatarSizesFlag)
+	if err != nil {
+		glog.Fatalf("Bad --avatar_sizes %s, use sizes between 16 and 1024", *avatarSizesFlag)
+	}
+	if *avatarDecodersFlag < 1 {
+		glog.Fatalf("Bad --avatar_decoders %d, at least one is needed", *avatarDecodersFlag)
+	}
+	avatarDecoders = make(chan struct{}, *avatarDecodersFlag)
+	baseURL := *avatarURL
+	if baseURL == "" {
+		baseURL = *apiRoot + "/avatars"
+	}
+	avatars, err = blob.NewStore(blob.Config{Kind: *avatarStore, Dir: *avatarDirFlag, BaseURL: baseURL})
+	if err != nil {
+		glog.Fatalf("Failed to create the avatar store: %v", err)
+	}
+
+	subs := []webhook.Subscription{}
+	if *webhookConfig != "" {
+		subs, err = webhook.LoadSubscriptions(*webhookConfig)
+		if err != nil {
+			glog.Fatalf("Failed to load webhooks: %v", err)
+		}
+	}
+	webhooks = webhook.NewDispatcher(subs, client)
+	webhooks.MaxAttempts = *webhookAttempts
+	webhooks.Backoff = *webhookBackoff
+
+	err = tenants.refresh()
+	if err != nil {
+		glog.Fatalf("Failed to load tenants: %v", err)
+	}
+	go tenants.refreshEvery(time.Minute)
+	if *blacklistFile != "" {
+		err = client.Blacklist().LoadFile(*blacklistFile)
+		if err != nil {
+			glog.Fatalf("Failed to load blacklist: %v", err)
+		}
+		go client.Blacklist().WatchFile(*blacklistFile, *blacklistReload)
+	}
+	err = client.RefreshBlacklist()
+	if err != nil {
+		glog.Fatalf("Failed to load blacklist entries: %v", err)
+	}
+	go refreshBlacklistEvery(*blacklistReload)
+	go purgeEvery(*purgeInterval)
+
+	r := newRouter()
 	srv := &http.Server{
 		Handler: r,
 		Addr:    *ip,
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/codemk8/muser/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useFakeStore serves the handlers from an empty in-memory table
+func useFakeStore(t *testing.T) *fakeDB {
+	db := newFakeDB()
+	c, err := dynamo.NewClientFor(db, "test")
+	assert.Nil(t, err)
+	sealer, err := verify.NewSealer(make([]byte, 32))
+	assert.Nil(t, err)
+	c.SetSealer(sealer)
+	client = c
+	webhooks = webhook.NewDispatcher(nil, c)
+	return db
+}
+
+// addUser stores a user with the password, changed by modify before
+func addUser(t *testing.T, name string, password string, modify func(*schema.User)) *schema.User {
+	// the lowest cost keeps the tests fast, comparing reads it from the hash
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.Nil(t, err)
+	user := schema.NewUser(name, string(hash))
+	user.DisplayName = name
+	if modify != nil {
+		modify(user)
+	}
+	assert.Nil(t, client.RegisterUser(user))
+	return user
+}
+
+// serve answers a request to path with the body, as the user if name is
+// not empty
+func serve(method string, path string, body string, name string, password string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if name != "" {
+		r.SetBasicAuth(name, password)
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+// decodeBody decodes the json body of a response into a map
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	body := map[string]interface{}{}
+	err := json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Nil(t, err, w.Body.String())
+	return body
+}
+
+func TestFakeStore(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "test_user", "secret1", nil)
+	user, err := client.GetUser("Test_User", false)
+	assert.Nil(t, err)
+	assert.Equal(t, user.UserName, "test_user", "found by canonical name")
+	assert.Equal(t, user.Secret.Salt, "", "the secret is not read")
+	assert.Equal(t, client.UserExist("nobody"), false)
+
+	w := serve("GET", "/v1/user/auth", "", "test_user", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	w = serve("GET", "/v1/user/auth", "", "test_user", "wrong")
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "wrong password")
+	user, err = client.GetUser("test_user", true)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Secret.FailedLogins, 1, "counted by an update")
+}
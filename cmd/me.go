@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// The /user/me endpoints act on the authenticated caller, never on a user
+// named in the body.
+
+// ProfileJSON changes the profile of the caller, empty fields are kept
+type ProfileJSON struct {
+	Locale   string `json:"locale,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	// custom attributes to set, null removes one
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// PasswordJSON changes the password of the caller
+type PasswordJSON struct {
+	NewPassword string `json:"new_password"`
+}
+
+// EmailJSON changes the email of the caller, once the new one is verified
+type EmailJSON struct {
+	Email string `json:"email"`
+}
+
+// decodeMe decodes the body of a /user/me request into v, it writes the
+// error response and returns false if it is not json
+func decodeMe(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		glog.Warningf("Failed to decode json: %v.", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// updateMe applies the update to the caller and answers the self view, the
+// caller's password, already checked, stands for the current one
+func updateMe(w http.ResponseWriter, r *http.Request, update UpdateUserJSON) {
+	_, update.Password, _ = r.BasicAuth()
+	err := update.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+	me := caller(r)
+	if !applyUpdate(w, r, me, update) {
+		return
+	}
+	writeJSON(w, selfView(me))
+}
+
+func getMeHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, selfView(caller(r)))
+}
+
+func patchMeHandler(w http.ResponseWriter, r *http.Request) {
+	req := ProfileJSON{}
+	if !decodeMe(w, r, &req) {
+		return
+	}
+	updateMe(w, r, UpdateUserJSON{Locale: req.Locale, Timezone: req.Timezone, Attributes: req.Attributes})
+}
+
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	req := PasswordJSON{}
+	if !decodeMe(w, r, &req) {
+		return
+	}
+	if req.NewPassword == "" {
+		http.Error(w, "bad request, needs new_password", http.StatusBadRequest)
+		return
+	}
+	updateMe(w, r, UpdateUserJSON{NewPassword: req.NewPassword})
+}
+
+func changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	req := EmailJSON{}
+	if !decodeMe(w, r, &req) {
+		return
+	}
+	if req.Email == "" {
+		http.Error(w, "bad request, needs email", http.StatusBadRequest)
+		return
+	}
+	updateMe(w, r, UpdateUserJSON{Email: req.Email})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMe(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "alice", "secret1", func(user *schema.User) {
+		user.Profile.Email = "alice@example.com"
+		user.Profile.Verified = true
+	})
+	w := serve("GET", "/v1/user/me", "", "", "")
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "needs credentials")
+	w = serve("GET", "/v1/user/me", "", "alice", "wrong")
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = serve("GET", "/v1/user/me", "", "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK)
+	me := decodeBody(t, w)
+	assert.Equal(t, me["user_name"], "alice")
+	profile, _ := me["profile"].(map[string]interface{})
+	assert.Equal(t, profile["email"], "alice@example.com", "self view")
+}
+
+func TestPatchMe(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "alice", "secret1", nil)
+	addUser(t, "bob", "secret1", nil)
+	w := serve("PATCH", "/v1/user/me", `{"user_name": "bob", "locale": "es", "timezone": "Europe/Madrid"}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	profile, _ := decodeBody(t, w)["profile"].(map[string]interface{})
+	assert.Equal(t, profile["locale"], "es")
+
+	alice, err := client.GetUser("alice", false)
+	assert.Nil(t, err)
+	assert.Equal(t, alice.Profile.Locale, "es")
+	assert.Equal(t, alice.Profile.Timezone, "Europe/Madrid")
+	bob, err := client.GetUser("bob", false)
+	assert.Nil(t, err)
+	assert.Equal(t, bob.Profile.Locale, "", "a name in the body is ignored")
+
+	w = serve("PATCH", "/v1/user/me", `{"timezone": "Mars/Olympus"}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusBadRequest, "invalid time zone")
+	w = serve("PATCH", "/v1/user/me", `not json`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+func TestChangeMyPassword(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "alice", "secret1", nil)
+	w := serve("POST", "/v1/user/me/password", `{}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusBadRequest, "needs new_password")
+	w = serve("POST", "/v1/user/me/password", `{"new_password": "secret2"}`, "alice", "wrong")
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "needs the current password")
+	w = serve("POST", "/v1/user/me/password", `{"new_password": "secret2"}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	w = serve("GET", "/v1/user/me", "", "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "the old password is gone")
+	w = serve("GET", "/v1/user/me", "", "alice", "secret2")
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestChangeMyEmail(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "alice", "secret1", func(user *schema.User) {
+		user.Profile.Email = "alice@example.com"
+		user.Profile.Verified = true
+	})
+	w := serve("POST", "/v1/user/me/email", `{}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusBadRequest, "needs email")
+	w = serve("POST", "/v1/user/me/email", `{"email": "not an email"}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = serve("POST", "/v1/user/me/email", `{"email": "new_alice@example.com"}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	me := decodeBody(t, w)
+	assert.Equal(t, me["pending_email"], "new_alice@example.com", "verified before it is used")
+	profile, _ := me["profile"].(map[string]interface{})
+	assert.Equal(t, profile["email"], "alice@example.com")
+}
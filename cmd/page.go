@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// PageJSON is a page of a listing, cursor fetches the next page and is
+// omitted on the last one
+type PageJSON struct {
+	Items  interface{} `json:"items"`
+	Cursor string      `json:"cursor,omitempty"`
+}
+
+// pageParams reads the cursor and limit query parameters
+func pageParams(r *http.Request) (string, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return r.URL.Query().Get("cursor"), limit
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
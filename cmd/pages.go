@@ -0,0 +1,87 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// Emailed links open in a browser with a GET, which must not change
+// anything: they lead to these pages, whose forms POST the change, as json
+// to the endpoints taking json.
+
+var pages = template.Must(template.New("pages").Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>{{.}}</title></head>
+<body><h1>{{.}}</h1>{{end}}
+{{define "foot"}}</body></html>{{end}}
+
+{{define "json"}}<p id="result"></p>
+<script>
+document.querySelector("form").addEventListener("submit", function (e) {
+  e.preventDefault();
+  var body = {};
+  new FormData(e.target).forEach(function (value, key) { body[key] = value; });
+  fetch(e.target.action, {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)})
+    .then(function (res) {
+      return res.text().then(function (text) {
+        document.getElementById("result").textContent = res.ok ? {{.}} : text;
+        if (res.ok) { e.target.remove(); }
+      });
+    });
+});
+</script>{{end}}
+
+{{define "invite"}}{{template "head" "Accept the invitation"}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="invite_token" value="{{.Token}}">
+<p><label>User name <input name="user_name" required></label></p>
+<p><label>Password <input type="password" name="password" required></label></p>
+<button type="submit">Register</button>
+</form>
+{{template "json" "Registered, you can sign in."}}
+{{template "foot"}}{{end}}
+
+{{define "reset"}}{{template "head" "Reset your password"}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="user_name" value="{{.UserName}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><label>New password <input type="password" name="new_password" required></label></p>
+<button type="submit">Reset</button>
+</form>
+{{template "json" "Password reset, you can sign in."}}
+{{template "foot"}}{{end}}
+
+{{define "revert"}}{{template "head" "Undo the email change"}}
+<p>The email of <b>{{.UserName}}</b> was changed. Undo the change to restore this address.</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="user_name" value="{{.UserName}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Undo the change</button>
+</form>
+{{template "foot"}}{{end}}
+
+{{define "reverted"}}{{template "head" "Email change undone"}}
+<p>The email of <b>{{.UserName}}</b> is this address again.</p>
+{{template "foot"}}{{end}}
+`))
+
+// pageData fills the templates of the pages
+type pageData struct {
+	UserName string
+	Token    string
+	// Action is the url the form posts to
+	Action string
+}
+
+func writePage(w http.ResponseWriter, name string, data pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the page carries a token, keep it out of caches and referrers
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err := pages.ExecuteTemplate(w, name, data)
+	if err != nil {
+		glog.Warningf("Failed to render page %s: %v", name, err)
+	}
+}
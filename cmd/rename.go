@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/codemk8/muser/pkg/webhook"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/golang/glog"
+)
+
+// maxRedirects bounds the renames followed from an old name
+const maxRedirects = 5
+
+// RenameJSON renames a user, the password is only needed to rename oneself
+type RenameJSON struct {
+	UserName string `json:"user_name,omitempty"`
+	Password string `json:"password,omitempty"`
+	NewName  string `json:"new_name,omitempty"`
+}
+
+// RenamedJSON tells a client looking up an old name the current one
+type RenamedJSON struct {
+	ErrorJSON
+	RenamedTo string `json:"renamed_to"`
+}
+
+func decodeRename(w http.ResponseWriter, r *http.Request) *RenameJSON {
+	req := RenameJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return nil
+	}
+	err = validation.ValidateStruct(&req,
+		validation.Field(&req.NewName, validation.Required, validation.Length(5, 32)))
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return nil
+	}
+	return &req
+}
+
+// renamedTo follows the redirects of the reservations of name to the
+// current name of the user, it returns "" if name was not renamed
+func renamedTo(r *http.Request, name string) string {
+	current := ""
+	for i := 0; i < maxRedirects; i++ {
+		res, err := store(r).GetReservation(name)
+		if err != nil || res.RedirectTo == "" || !res.Active() {
+			break
+		}
+		current, name = res.RedirectTo, res.RedirectTo
+		if store(r).UserExist(current) {
+			return current
+		}
+	}
+	return ""
+}
+
+// getLinkedUser reads the user, with its secret, named in an emailed link,
+// following the rename of a user renamed after the link was sent
+func getLinkedUser(r *http.Request, name string) (*schema.User, error) {
+	user, err := store(r).GetUser(name, true)
+	if err == nil {
+		return user, nil
+	}
+	if canonical, cerr := schema.CanonicalUserName(name); cerr == nil {
+		name = canonical
+	}
+	current := renamedTo(r, name)
+	if current == "" {
+		return nil, err
+	}
+	return store(r).GetUser(current, true)
+}
+
+// writeUserNotFound writes the response to the lookup of a missing user,
+// telling the current name of renamed users
+func writeUserNotFound(w http.ResponseWriter, r *http.Request, name string, status int, message string) {
+	key, err := schema.CanonicalUserName(name)
+	if err != nil {
+		key = name
+	}
+	current := renamedTo(r, key)
+	if current == "" {
+		http.Error(w, message, status)
+		return
+	}
+	b, _ := json.Marshal(RenamedJSON{
+		ErrorJSON: ErrorJSON{Code: codeUserRenamed, Message: "The user was renamed"},
+		RenamedTo: current,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(b)
+}
+
+// rename moves dbUser to newName, the old name is reserved for
+// --rename_cooldown and redirects to the new one
+func rename(w http.ResponseWriter, r *http.Request, dbUser *schema.User, newName string) {
+	name, err := schema.CanonicalUserName(newName)
+	if err != nil {
+		http.Error(w, "bad request, "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name == dbUser.UserName {
+		http.Error(w, "bad request, same user name", http.StatusBadRequest)
+		return
+	}
+	if store(r).BadUserName(name) {
+		http.Error(w, "username is not available", http.StatusBadRequest)
+		return
+	}
+	if store(r).Confusable(name) {
+		http.Error(w, "bad request, "+schema.ErrConfusable.Error(), http.StatusBadRequest)
+		return
+	}
+	// users can take back their previous names
+	if res, err := store(r).GetReservation(name); err != dynamo.ErrNotFound &&
+		(err != nil || (res.Active() && res.RedirectTo != dbUser.UserName)) {
+		http.Error(w, "username is not available", http.StatusBadRequest)
+		return
+	}
+	oldName := dbUser.UserName
+	dbUser.DisplayName = newName
+	// codes are hashed with the name, a pending one is sent again for the new
+	notes := []verify.VerifyRequest{}
+	if dbUser.Secret.VerifyCode != "" && time.Now().Unix() < dbUser.Secret.CodeExpiry {
+		renamed := *dbUser
+		renamed.UserName = name
+		notes = append(notes, newVerifyCode(&renamed))
+		dbUser.Secret = renamed.Secret
+	} else {
+		dbUser.Secret.ClearVerifyCode()
+	}
+	res := schema.NewReservation(oldName, "renamed", *renameCooldown)
+	err = store(r).RenameUser(dbUser, name, res, notes...)
+	switch err {
+	case nil:
+	case dynamo.ErrExists:
+		http.Error(w, "the username already exist", http.StatusConflict)
+		return
+	case dynamo.ErrEmailTaken:
+		writeError(w, http.StatusConflict, codeEmailTaken, "The email belongs to another account")
+		return
+	default:
+		writeStoreError(w, err)
+		return
+	}
+	glog.Infof("Renamed %s to %s", oldName, name)
+	webhooks.Publish(webhook.UserRenamed, store(r).Tenant(), name, map[string]interface{}{"previous": oldName})
+	dbUser.UserName = name
+	writeJSON(w, accountOf(dbUser))
+}
+
+// renameHandler renames the caller, who re-enters the password
+func renameHandler(w http.ResponseWriter, r *http.Request) {
+	req := decodeRename(w, r)
+	if req == nil {
+		return
+	}
+	dbUser, err := store(r).GetUser(req.UserName, true)
+	if err != nil {
+		writeInvalidCredentials(w)
+		return
+	}
+	if !checkPassword(w, r, dbUser, req.Password) || !admitted(w, r, dbUser) {
+		return
+	}
+	rename(w, r, dbUser, req.NewName)
+}
+
+func adminRenameHandler(w http.ResponseWriter, r *http.Request) {
+	dbUser := pathUser(w, r)
+	if dbUser == nil {
+		return
+	}
+	req := decodeRename(w, r)
+	if req == nil {
+		return
+	}
+	glog.Infof("User %s renames %s", caller(r).UserName, dbUser.UserName)
+	rename(w, r, dbUser, req.NewName)
+}
+
+// migrateUserNames moves the users of every tenant to their canonical name,
+// before any lookup by canonical name can serve a newer user in their place
+func migrateUserNames() error {
+	list, err := client.ListTenants()
+	if err != nil {
+		return err
+	}
+	stores := []*dynamo.DynamoClient{client}
+	for i := range list {
+		stores = append(stores, client.ForTenant(&list[i]))
+	}
+	for _, s := range stores {
+		err = s.MigrateUserNames()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRename(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "old_name", "secret1", nil)
+	// more groups than a rename transaction can hold
+	for i := 0; i < 15; i++ {
+		group := fmt.Sprintf("group%d", i)
+		assert.Nil(t, client.CreateGroup(&schema.Group{Name: group}))
+		assert.Nil(t, client.AddMember(group, "old_name"))
+	}
+
+	w := serve("POST", "/v1/user/rename", `{"user_name": "old_name", "password": "wrong", "new_name": "new_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "wrong password")
+	w = serve("POST", "/v1/user/rename", `{"user_name": "old_name", "password": "secret1", "new_name": "New_Name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	assert.Equal(t, decodeBody(t, w)["user_name"], "new_name", "canonical")
+
+	renamed, err := client.GetUser("new_name", false)
+	assert.Nil(t, err)
+	assert.Equal(t, renamed.DisplayName, "New_Name")
+	assert.Equal(t, len(renamed.Groups), 15)
+	assert.Equal(t, len(renamed.NameHistory), 1)
+	members, _, err := client.ListMembers("group14", "", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, len(members), 1)
+	assert.Equal(t, members[0].Member, "new_name", "the memberships follow")
+	assert.Equal(t, client.UserExist("old_name"), false)
+
+	w = serve("POST", "/v1/user/rename", `{"user_name": "new_name", "password": "secret1", "new_name": "new_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusBadRequest, "same name")
+	addUser(t, "other_user", "secret1", nil)
+	w = serve("POST", "/v1/user/rename", `{"user_name": "other_user", "password": "secret1", "new_name": "old_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusBadRequest, "the old name is reserved for the renamed user")
+}
+
+func TestRenameRequiresVerified(t *testing.T) {
+	useFakeStore(t)
+	*requireVerifiedFlag = true
+	defer func() { *requireVerifiedFlag = false }()
+	addUser(t, "late_user", "secret1", func(user *schema.User) {
+		user.Created = time.Now().Add(-2 * *verifyGrace).Unix()
+	})
+	w := serve("POST", "/v1/user/rename", `{"user_name": "late_user", "password": "secret1", "new_name": "new_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	assert.Equal(t, decodeBody(t, w)["error"], codeEmailNotVerified)
+	assert.Equal(t, client.UserExist("late_user"), true, "not renamed")
+}
+
+func TestRenamedLookup(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "old_name", "secret1", nil)
+	w := serve("POST", "/v1/user/rename", `{"user_name": "old_name", "password": "secret1", "new_name": "new_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+
+	w = serve("POST", "/v1/user", `{"user_name": "Old_Name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	body := decodeBody(t, w)
+	assert.Equal(t, body["error"], codeUserRenamed)
+	assert.Equal(t, body["renamed_to"], "new_name", "any form of the old name")
+	w = serve("GET", "/v2/users/old_name", "", "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	assert.Equal(t, decodeBody(t, w)["renamed_to"], "new_name")
+	w = serve("POST", "/v1/user", `{"user_name": "new_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, decodeBody(t, w)["user_name"], "new_name")
+
+	// a rename back lifts the redirect of the new name
+	w = serve("POST", "/v1/user/rename", `{"user_name": "new_name", "password": "secret1", "new_name": "old_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	w = serve("POST", "/v1/user", `{"user_name": "old_name"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = serve("POST", "/v1/user", `{"user_name": "new_name"}`, "", "")
+	assert.Equal(t, decodeBody(t, w)["renamed_to"], "old_name")
+	w = serve("POST", "/v1/user", `{"user_name": "nobody"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound, "never existed")
+	assert.Equal(t, strings.Contains(w.Body.String(), "renamed_to"), false)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+// RoleJSON is a request to grant or revoke a role
+type RoleJSON struct {
+	UserName string `json:"user_name,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+func decodeRole(w http.ResponseWriter, r *http.Request) *RoleJSON {
+	req := RoleJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		glog.Warningf("Failed to decode json: %v.", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return nil
+	}
+	if req.UserName == "" || !schema.ValidRole(req.Role) || req.Role == schema.RoleUser {
+		http.Error(w, "bad request, needs usename and a valid role", http.StatusBadRequest)
+		return nil
+	}
+	req.UserName = userKey(r, req.UserName)
+	return &req
+}
+
+func grantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	req := decodeRole(w, r)
+	if req == nil {
+		return
+	}
+	err := store(r).GrantRole(req.UserName, req.Role)
+	if !writeRoleError(w, err) {
+		return
+	}
+	glog.Infof("User %s granted role %s to %s", caller(r).UserName, req.Role, req.UserName)
+}
+
+func revokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	req := decodeRole(w, r)
+	if req == nil {
+		return
+	}
+	if req.UserName == caller(r).UserName && req.Role == schema.RoleAdmin {
+		http.Error(w, "can not revoke your own admin role", http.StatusBadRequest)
+		return
+	}
+	err := store(r).RevokeRole(req.UserName, req.Role)
+	if !writeRoleError(w, err) {
+		return
+	}
+	glog.Infof("User %s revoked role %s from %s", caller(r).UserName, req.Role, req.UserName)
+}
+
+// writeRoleError writes the error response of a role update, it returns
+// true if there was no error
+func writeRoleError(w http.ResponseWriter, err error) bool {
+	if err == dynamo.ErrNotFound {
+		http.Error(w, "user not found", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+// Error codes of ErrorJSON, clients can rely on them
+const (
+	codeInvalidCredentials    = "invalid_credentials"
+	codeAccountSuspended      = "account_suspended"
+	codeAccountLocked         = "account_locked"
+	codePendingVerification   = "account_pending_verification"
+	codeAccountDeleted        = "account_deleted"
+	codePasswordResetRequired = "password_reset_required"
+	codeEmailNotVerified      = "email_not_verified"
+	codeEmailTaken            = "email_taken"
+	codeUserRenamed           = "user_renamed"
+	codeUserChanged           = "user_changed"
+)
+
+// ErrorJSON is the body of the errors clients act on. The message is for
+// humans and tells nothing the code does not, e.g. no suspension reason.
+type ErrorJSON struct {
+	Code    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	b, _ := json.Marshal(ErrorJSON{Code: code, Message: message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeInvalidCredentials(w http.ResponseWriter) {
+	writeError(w, http.StatusUnauthorized, codeInvalidCredentials, "Invalid user name or password")
+}
+
+// writeStatusError writes the error of an account that is not active, or
+// whose password must be reset
+func writeStatusError(w http.ResponseWriter, user *schema.User) {
+	status := user.CurrentStatus()
+	if user.Secret.MustResetPassword && (status == schema.StatusActive || status == schema.StatusPendingVerification) {
+		writeError(w, http.StatusForbidden, codePasswordResetRequired, "Reset your password with the link sent to your email")
+		return
+	}
+	switch status {
+	case schema.StatusSuspended:
+		writeError(w, http.StatusForbidden, codeAccountSuspended, "Account suspended")
+	case schema.StatusLocked:
+		writeError(w, http.StatusForbidden, codeAccountLocked, "Account locked after too many failed logins, try again later")
+	case schema.StatusPendingVerification:
+		writeError(w, http.StatusForbidden, codePendingVerification, "Verify your email to activate the account")
+	case schema.StatusDeleted:
+		writeError(w, http.StatusForbidden, codeAccountDeleted, "Account deleted")
+	default:
+		writeError(w, http.StatusForbidden, codeAccountSuspended, "Account unavailable")
+	}
+}
+
+// selfService tells if the user can still manage the account, e.g. update
+// it or verify its email, which pending verification accounts need to. Not
+// until a password revoked by an admin is reset.
+func selfService(user *schema.User) bool {
+	status := user.CurrentStatus()
+	return (status == schema.StatusActive || status == schema.StatusPendingVerification) &&
+		!user.Secret.MustResetPassword
+}
+
+// requireVerified returns true if authentication needs a verified email in
+// the tenant of the request
+func requireVerified(r *http.Request) bool {
+	tenant := tenantOf(r)
+	return *requireVerifiedFlag || (tenant != nil && tenant.Settings.RequireVerified)
+}
+
+// unverified tells if the user is past the --verify_grace allowed after
+// registration without a verified email
+func unverified(r *http.Request, user *schema.User) bool {
+	if user.Profile.Verified || !requireVerified(r) {
+		return false
+	}
+	return time.Since(time.Unix(user.Created, 0)) > *verifyGrace
+}
+
+// checkPassword compares password with the user's, wrong passwords are
+// counted and lock the account at --max_failed_logins. It writes the error
+// response and returns false unless the password is right. A locked account
+// is rejected before the password is compared so guessing gets nothing.
+func checkPassword(w http.ResponseWriter, r *http.Request, user *schema.User, password string) bool {
+	if user.CurrentStatus() == schema.StatusLocked {
+		writeStatusError(w, user)
+		return false
+	}
+	if CheckPasswordHash(password, user.Secret.Salt) {
+		if user.Secret.FailedLogins > 0 {
+			err := store(r).ClearFailedLogins(user)
+			if err != nil {
+				glog.Warningf("Failed to clear failed logins of %s: %v", user.UserName, err)
+			}
+		}
+		if user.Secret.MustResetPassword {
+			// an admin revoked the password, only the reset link works
+			writeStatusError(w, user)
+			return false
+		}
+		return true
+	}
+	glog.Warningf("Wrong password for %s", user.UserName)
+	if *maxFailedLogins > 0 {
+		count, err := store(r).FailedLogin(user.UserName)
+		if err != nil {
+			glog.Warningf("Failed to count failed login of %s: %v", user.UserName, err)
+		} else if count >= *maxFailedLogins {
+			if !user.Lockable() {
+				// a lock would end the suspension, deletion or pending
+				// verification once over
+				writeStatusError(w, user)
+				return false
+			}
+			lockUser(r, user)
+		}
+	}
+	writeInvalidCredentials(w)
+	return false
+}
+
+// lockUser locks the active account for --lockout_period and tells the user
+func lockUser(r *http.Request, user *schema.User) {
+	until := time.Now().Add(*lockoutPeriod)
+	notes := []verify.VerifyRequest{}
+	if user.Profile.Verified {
+		notes = append(notes, verify.VerifyRequest{
+			Type:     verify.TypeLockout,
+			UserName: user.UserName,
+			To:       user.Profile.Email,
+			Locale:   user.Profile.Locale,
+			Time:     until.Unix(),
+		})
+	}
+	glog.Warningf("Locking %s after %d failed logins", user.UserName, *maxFailedLogins)
+	err := store(r).LockUser(user.UserName, until.Unix(), notes...)
+	if err == dynamo.ErrNotFound {
+		glog.Warningf("Not locking %s, its status changed", user.UserName)
+	} else if err != nil {
+		glog.Warningf("Failed to lock %s: %v", user.UserName, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireVerified(t *testing.T) {
+	useFakeStore(t)
+	*requireVerifiedFlag = true
+	defer func() { *requireVerifiedFlag = false }()
+	past := func(user *schema.User) {
+		user.Created = time.Now().Add(-2 * *verifyGrace).Unix()
+	}
+	addUser(t, "new_user", "secret1", nil)
+	addUser(t, "late_user", "secret1", past)
+	addUser(t, "verified_user", "secret1", func(user *schema.User) {
+		past(user)
+		user.Profile.Email = "verified@example.com"
+		user.Profile.Verified = true
+	})
+
+	w := serve("GET", "/v1/user/auth", "", "new_user", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, "within the grace period")
+	w = serve("GET", "/v1/user/auth", "", "verified_user", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, "verified")
+	w = serve("GET", "/v1/user/auth", "", "late_user", "secret1")
+	assert.Equal(t, w.Code, http.StatusForbidden, "past the grace period")
+	assert.Equal(t, decodeBody(t, w)["error"], codeEmailNotVerified)
+	w = serve("GET", "/v1/user/auth", "", "late_user", "wrong")
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "the password is checked first")
+
+	*requireVerifiedFlag = false
+	w = serve("GET", "/v1/user/auth", "", "late_user", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, "not required")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	dynamo "github.com/codemk8/muser/pkg/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const tenantKey contextKey = 1
+
+// tenantCache keeps the tenants in memory for host based resolution
+type tenantCache struct {
+	mu     sync.RWMutex
+	byName map[string]*schema.Tenant
+	byHost map[string]*schema.Tenant
+}
+
+var tenants = &tenantCache{}
+
+func (c *tenantCache) refresh() error {
+	list, err := client.ListTenants()
+	if err != nil {
+		return err
+	}
+	byName := map[string]*schema.Tenant{}
+	byHost := map[string]*schema.Tenant{}
+	for i := range list {
+		t := &list[i]
+		byName[t.Name] = t
+		for _, host := range t.Hosts {
+			byHost[strings.ToLower(host)] = t
+		}
+	}
+	c.mu.Lock()
+	c.byName = byName
+	c.byHost = byHost
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *tenantCache) refreshEvery(period time.Duration) {
+	for range time.Tick(period) {
+		err := c.refresh()
+		if err != nil {
+			glog.Warningf("Failed to refresh tenants: %v", err)
+		}
+	}
+}
+
+// get returns a tenant, falling back to the store for tenants created
+// since the last refresh
+func (c *tenantCache) get(name string) *schema.Tenant {
+	c.mu.RLock()
+	t := c.byName[name]
+	c.mu.RUnlock()
+	if t != nil {
+		return t
+	}
+	t, err := client.GetTenant(name)
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+// forHost returns the tenant listing the host, or named by the label before
+// --tenant_domain
+func (c *tenantCache) forHost(host string) *schema.Tenant {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if t := c.byHost[host]; t != nil {
+		return t
+	}
+	domain := tenantDomainName()
+	if domain == "" || !strings.HasSuffix(host, "."+domain) {
+		return nil
+	}
+	label := strings.TrimSuffix(host, "."+domain)
+	if strings.Contains(label, ".") {
+		return nil
+	}
+	return c.byName[label]
+}
+
+// tenantDomainName is --tenant_domain lowercased, without a leading dot
+func tenantDomainName() string {
+	return strings.TrimPrefix(strings.ToLower(*tenantDomain), ".")
+}
+
+// tenantHost is the host of the tenant in links, its first listed host or
+// its name under --tenant_domain, never the host a request came with
+func tenantHost(tenant *schema.Tenant) string {
+	if len(tenant.Hosts) > 0 {
+		return strings.ToLower(tenant.Hosts[0])
+	}
+	if domain := tenantDomainName(); domain != "" {
+		return tenant.Name + "." + domain
+	}
+	return ""
+}
+
+// resolveTenant finds the tenant of the request according to --tenant_mode,
+// requests resolving to no tenant are served by the default tenant
+func resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tenant *schema.Tenant
+		if name, ok := mux.Vars(r)["tenant"]; ok {
+			tenant = tenants.get(name)
+			if tenant == nil {
+				http.Error(w, "tenant not found", http.StatusNotFound)
+				return
+			}
+		} else if *tenantMode == "host" {
+			tenant = tenants.forHost(r.Host)
+		}
+		if tenant != nil {
+			r = r.WithContext(context.WithValue(r.Context(), tenantKey, tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tenantOf returns the tenant of the request, nil for the default tenant
+func tenantOf(r *http.Request) *schema.Tenant {
+	tenant, _ := r.Context().Value(tenantKey).(*schema.Tenant)
+	return tenant
+}
+
+// store returns the client scoped to the tenant of the request
+func store(r *http.Request) *dynamo.DynamoClient {
+	return client.ForTenant(tenantOf(r))
+}
+
+// defaultTenantOnly hides routes managing all tenants from tenant requests
+func defaultTenantOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tenantOf(r) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// apiURL is the external url of the api root for the tenant of the request,
+// used in links sent by email
+func apiURL(r *http.Request) string {
+	base := *publicURL
+	if base == "" {
+		base = "http://" + *ip
+	}
+	tenant := tenantOf(r)
+	if tenant == nil {
+		return base + *apiRoot
+	}
+	if *tenantMode == "path" {
+		return base + *apiRoot + "/t/" + tenant.Name
+	}
+	host := tenantHost(tenant)
+	u, err := url.Parse(base)
+	if err != nil || host == "" {
+		return base + *apiRoot
+	}
+	if _, port, err := net.SplitHostPort(u.Host); err == nil {
+		host = net.JoinHostPort(host, port)
+	}
+	u.Host = host
+	return u.String() + *apiRoot
+}
+
+// TenantJSON creates or updates a tenant, the admin is only used on creation
+type TenantJSON struct {
+	Name          string                `json:"name,omitempty"`
+	Hosts         []string              `json:"hosts,omitempty"`
+	Blacklist     []string              `json:"blacklist,omitempty"`
+	Settings      schema.TenantSettings `json:"settings"`
+	AdminUser     string                `json:"admin_user,omitempty"`
+	AdminPassword string                `json:"admin_password,omitempty"`
+}
+
+func (t TenantJSON) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.Name, validation.Required, validation.Match(schema.TenantNameRegexp)),
+		validation.Field(&t.Blacklist, validation.By(validPatterns)),
+		validation.Field(&t.Settings),
+	)
+}
+
+func decodeTenant(w http.ResponseWriter, r *http.Request) *TenantJSON {
+	req := TenantJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		glog.Warningf("Failed to decode json: %v.", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return nil
+	}
+	if name, ok := mux.Vars(r)["name"]; ok {
+		req.Name = name
+	}
+	err = req.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return nil
+	}
+	return &req
+}
+
+// createTenantHandler creates a tenant with its first admin
+func createTenantHandler(w http.ResponseWriter, r *http.Request) {
+	req := decodeTenant(w, r)
+	if req == nil {
+		return
+	}
+	admin := UserJSON{UserName: req.AdminUser, Password: req.AdminPassword}
+	err := admin.Validate()
+	if err != nil {
+		b, _ := json.Marshal(err)
+		http.Error(w, string(b), http.StatusBadRequest)
+		return
+	}
+	name, err := schema.CanonicalUserName(admin.UserName)
+	if err != nil {
+		http.Error(w, "bad request, admin "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenant := schema.NewTenant(req.Name)
+	tenant.Hosts = req.Hosts
+	tenant.Blacklist = req.Blacklist
+	tenant.Settings = req.Settings
+	err = client.CreateTenant(tenant)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	hash, err := HashPassword(admin.Password)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	first := schema.NewUser(name, hash)
+	first.DisplayName = admin.UserName
+	_, err = client.ForTenant(tenant).Bootstrap(first, false)
+	if err != nil {
+		glog.Warningf("Failed to create admin of tenant %s: %v", tenant.Name, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	tenants.refresh()
+	glog.Infof("User %s created tenant %s", caller(r).UserName, tenant.Name)
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, tenant)
+}
+
+func listTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := client.ListTenants()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, list)
+}
+
+func getTenantHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, err := client.GetTenant(mux.Vars(r)["name"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, tenant)
+}
+
+// updateTenantHandler replaces the hosts, blacklist and settings of a tenant
+func updateTenantHandler(w http.ResponseWriter, r *http.Request) {
+	req := decodeTenant(w, r)
+	if req == nil {
+		return
+	}
+	tenant, err := client.GetTenant(req.Name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	tenant.Hosts = req.Hosts
+	tenant.Blacklist = req.Blacklist
+	tenant.Settings = req.Settings
+	err = client.UpdateTenant(tenant)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	tenants.refresh()
+	writeJSON(w, tenant)
+}
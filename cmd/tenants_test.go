@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForHost(t *testing.T) {
+	acme := schema.NewTenant("acme")
+	acme.Hosts = []string{"users.acme.com"}
+	cache := &tenantCache{
+		byName: map[string]*schema.Tenant{"acme": acme},
+		byHost: map[string]*schema.Tenant{"users.acme.com": acme},
+	}
+	*tenantDomain = "muser.example.com"
+	defer func() { *tenantDomain = "" }()
+	assert.Equal(t, cache.forHost("users.acme.com:8000"), acme, "listed host")
+	assert.Equal(t, cache.forHost("ACME.muser.example.com"), acme, "label of the tenant domain")
+	assert.Nil(t, cache.forHost("acme.evil.com"), "label of another domain")
+	assert.Nil(t, cache.forHost("acme.muser.example.com.evil.com"), "tenant domain as a label")
+	assert.Nil(t, cache.forHost("x.acme.muser.example.com"), "nested label")
+	assert.Nil(t, cache.forHost("muser.example.com"), "the domain itself")
+}
+
+func TestAPIURL(t *testing.T) {
+	*tenantMode = "host"
+	*publicURL = "https://muser.example.com:8443"
+	defer func() { *tenantMode, *publicURL = "", "" }()
+	r := httptest.NewRequest("GET", "/v1/user/register", nil)
+	r.Host = "acme.evil.com"
+	assert.Equal(t, apiURL(r), "https://muser.example.com:8443/v1", "default tenant")
+
+	acme := schema.NewTenant("acme")
+	acme.Hosts = []string{"users.acme.com"}
+	r = r.WithContext(context.WithValue(r.Context(), tenantKey, acme))
+	assert.Equal(t, apiURL(r), "https://users.acme.com:8443/v1", "first host of the tenant")
+
+	acme.Hosts = nil
+	*tenantDomain = "muser.example.com"
+	defer func() { *tenantDomain = "" }()
+	assert.Equal(t, apiURL(r), "https://acme.muser.example.com:8443/v1", "tenant under the domain")
+}
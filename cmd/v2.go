@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/gorilla/mux"
+)
+
+// The v2 API serves users as the resource /users/{name}, where name can
+// also be the user's ID. It shares the handlers of v1, which tell the APIs
+// apart with isV2 where v1 answers differently: v2 creates with 201 and a
+// Location, answers 409 to conflicts and 404 to missing users, and always
+// answers success with a json body.
+
+const versionKey contextKey = 2
+
+// apiV2 marks the requests of the v2 API
+func apiV2(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey, 2)))
+	})
+}
+
+// isV2 tells if the request came through the v2 API
+func isV2(r *http.Request) bool {
+	version, _ := r.Context().Value(versionKey).(int)
+	return version == 2
+}
+
+// conflictStatus is the status of requests conflicting with existing
+// users, v1 answers them as bad requests
+func conflictStatus(r *http.Request) int {
+	if isV2(r) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// missingStatus is the status of requests naming a missing user, v1 answers
+// them with status
+func missingStatus(r *http.Request, status int) int {
+	if isV2(r) {
+		return http.StatusNotFound
+	}
+	return status
+}
+
+// writeCreated answers the creation of the resource name under the
+// requested collection with its location
+func writeCreated(w http.ResponseWriter, r *http.Request, name string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+url.PathEscape(name))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// pathName returns the user named by the path, v1 names it in the body
+func pathName(r *http.Request, body string) string {
+	if name, ok := mux.Vars(r)["name"]; ok {
+		return name
+	}
+	return body
+}
+
+// namesCaller tells if name, in any form, or the ID is the caller's
+func namesCaller(r *http.Request, name string) bool {
+	me := caller(r)
+	if name == me.UserName || name == me.ID {
+		return true
+	}
+	canonical, err := schema.CanonicalUserName(name)
+	return err == nil && canonical == me.UserName
+}
+
+// selfOnly lets only the user named in the path through to next
+func selfOnly(next http.HandlerFunc) http.HandlerFunc {
+	return requirePermission("", func(w http.ResponseWriter, r *http.Request) {
+		if !namesCaller(r, mux.Vars(r)["name"]) {
+			writeError(w, http.StatusForbidden, "forbidden", "Only the user can do this")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// PatchUserJSON changes the user of a v2 PATCH, empty fields are kept
+type PatchUserJSON struct {
+	Email       string `json:"email,omitempty"`
+	NewPassword string `json:"new_password,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	// custom attributes to set, null removes one
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// getUserV2Handler returns the view of the user named or identified by the
+// path
+func getUserV2Handler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if schema.ValidUserID(name) {
+		writeUserByID(w, r, name)
+		return
+	}
+	writeUser(w, r, name)
+}
+
+func patchUserV2Handler(w http.ResponseWriter, r *http.Request) {
+	req := PatchUserJSON{}
+	if !decodeMe(w, r, &req) {
+		return
+	}
+	updateMe(w, r, UpdateUserJSON{
+		Email:       req.Email,
+		NewPassword: req.NewPassword,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+		Attributes:  req.Attributes,
+	})
+}
+
+func deleteUserV2Handler(w http.ResponseWriter, r *http.Request) {
+	me := caller(r)
+	if !softDelete(w, r, me) {
+		return
+	}
+	writeJSON(w, selfView(me))
+}
+
+func v2Routes(r *mux.Router) {
+	r.HandleFunc("/users", registerHandler).Methods("POST")
+	r.HandleFunc("/users/{name}", allowAnonymous(schema.PermUserRead, getUserV2Handler)).Methods("GET")
+	r.HandleFunc("/users/{name}", selfOnly(patchUserV2Handler)).Methods("PATCH")
+	r.HandleFunc("/users/{name}", selfOnly(deleteUserV2Handler)).Methods("DELETE")
+	r.HandleFunc("/users/{name}/verify", verifyHandler).Methods("POST")
+	r.HandleFunc("/users/{name}/verify/resend", resendHandler).Methods("POST")
+	r.HandleFunc("/users/{name}/avatar", selfOnly(uploadAvatarHandler)).Methods("PUT")
+	r.HandleFunc("/users/{name}/avatar", selfOnly(deleteAvatarHandler)).Methods("DELETE")
+	r.HandleFunc("/users/{name}/groups", requirePermission(schema.PermGroupsRead, userGroupsHandler)).Methods("GET")
+}
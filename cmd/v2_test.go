@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateUserV2(t *testing.T) {
+	useFakeStore(t)
+	w := serve("POST", "/v2/users", `{"user_name": "Alice", "password": "secret1"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusCreated, w.Body.String())
+	assert.Equal(t, w.Header().Get("Location"), "/v2/users/alice", "the canonical name")
+	created := decodeBody(t, w)
+	assert.Equal(t, created["user_name"], "alice")
+	assert.Equal(t, created["display_name"], "Alice")
+	assert.Equal(t, client.UserExist("alice"), true)
+
+	w = serve("POST", "/v2/users", `{"user_name": "alice", "password": "secret1"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusConflict, "taken")
+	w = serve("POST", "/v2/users", `{"user_name": "ALICE", "password": "secret1"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusConflict, "taken in another form")
+	w = serve("POST", "/v1/user/register", `{"user_name": "alice", "password": "secret1"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusBadRequest, "v1 answers conflicts as bad requests")
+	w = serve("POST", "/v2/users", `{"user_name": "alice"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusBadRequest, "no password")
+}
+
+func TestMissingUserV2(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "alice", "secret1", nil)
+	w := serve("GET", "/v2/users/nobody", "", "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	w = serve("GET", "/v2/users/alice", "", "", "")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, decodeBody(t, w)["user_name"], "alice")
+
+	w = serve("POST", "/v2/users/nobody/verify", `{"verify_code": "123456"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	w = serve("POST", "/v1/user/verify", `{"user_name": "nobody", "verify_code": "123456"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "v1 answers missing users as unauthorized")
+	w = serve("POST", "/v2/users/nobody/verify/resend", `{}`, "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func TestSelfOnlyV2(t *testing.T) {
+	useFakeStore(t)
+	alice := addUser(t, "alice", "secret1", nil)
+	addUser(t, "bob", "secret1", nil)
+	w := serve("PATCH", "/v2/users/alice", `{"locale": "es"}`, "bob", "secret1")
+	assert.Equal(t, w.Code, http.StatusForbidden, "only the user")
+	w = serve("PATCH", "/v2/users/alice", `{"locale": "es"}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	profile, _ := decodeBody(t, w)["profile"].(map[string]interface{})
+	assert.Equal(t, profile["locale"], "es")
+	w = serve("PATCH", "/v2/users/"+alice.ID, `{"timezone": "UTC"}`, "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, "named by ID")
+
+	w = serve("DELETE", "/v2/users/alice", "", "bob", "secret1")
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = serve("DELETE", "/v2/users/alice", "", "alice", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+	assert.Equal(t, decodeBody(t, w)["status"], "deleted", "json body")
+	w = serve("GET", "/v2/users/alice", "", "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
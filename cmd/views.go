@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/codemk8/muser/pkg/schema"
+)
+
+// A user is seen through one of three views: the public view for anyone,
+// the self view for the user and the admin view for admins. Every view
+// lists the fields it shows, so a new field stays hidden until added to a
+// view.
+
+// PublicUserJSON is what anyone, anonymous callers included, sees of a user
+type PublicUserJSON struct {
+	ID          string `json:"id,omitempty"`
+	UserName    string `json:"user_name"`
+	DisplayName string `json:"display_name,omitempty"`
+	Avatar      string `json:"avatar,omitempty"`
+	// the public custom attributes
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// SelfUserJSON is what users see of themselves
+type SelfUserJSON struct {
+	ID           string              `json:"id,omitempty"`
+	UserName     string              `json:"user_name"`
+	DisplayName  string              `json:"display_name,omitempty"`
+	Created      int64               `json:"created,omitempty"`
+	Profile      schema.Profile      `json:"profile"`
+	PendingEmail string              `json:"pending_email,omitempty"`
+	Roles        []string            `json:"roles,omitempty"`
+	Groups       []string            `json:"groups,omitempty"`
+	InviteQuota  int                 `json:"invite_quota,omitempty"`
+	Status       string              `json:"status"`
+	StatusUntil  int64               `json:"status_until,omitempty"`
+	NameHistory  []schema.NameChange `json:"name_history,omitempty"`
+}
+
+func publicView(r *http.Request, user *schema.User) PublicUserJSON {
+	return PublicUserJSON{
+		ID:          user.ID,
+		UserName:    user.UserName,
+		DisplayName: user.DisplayName,
+		Avatar:      user.Profile.Avatar,
+		Attributes:  attributeSchema(r).Public(user.Profile.Attributes),
+	}
+}
+
+func selfView(user *schema.User) SelfUserJSON {
+	return SelfUserJSON{
+		ID:           user.ID,
+		UserName:     user.UserName,
+		DisplayName:  user.DisplayName,
+		Created:      user.Created,
+		Profile:      user.Profile,
+		PendingEmail: user.Secret.PendingEmail,
+		Roles:        user.Roles,
+		Groups:       user.Groups,
+		InviteQuota:  user.InviteQuota,
+		Status:       user.CurrentStatus(),
+		StatusUntil:  user.StatusUntil,
+		NameHistory:  user.NameHistory,
+	}
+}
+
+// visible tells if the caller can see user at all, deleted users are only
+// seen by admins
+func visible(r *http.Request, user *schema.User) bool {
+	if user.Status != schema.StatusDeleted {
+		return true
+	}
+	viewer := caller(r)
+	return viewer != nil && viewer.HasPermission(schema.PermUsersAdmin)
+}
+
+// userView returns the view of user for viewer, which is nil for anonymous
+// callers
+func userView(r *http.Request, user *schema.User, viewer *schema.User) interface{} {
+	switch {
+	case viewer == nil:
+		return publicView(r, user)
+	case viewer.HasPermission(schema.PermUsersAdmin):
+		return adminView(user)
+	case viewer.UserName == user.UserName:
+		return selfView(user)
+	}
+	return publicView(r, user)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserViews(t *testing.T) {
+	useFakeStore(t)
+	profileAttributes = schema.AttributeSchema{
+		{Name: "team", Type: schema.AttrString, Visibility: schema.VisibilityPublic},
+		{Name: "phone", Type: schema.AttrString},
+	}
+	defer func() { profileAttributes = nil }()
+	alice := addUser(t, "alice", "secret1", func(user *schema.User) {
+		user.Profile.Email = "alice@example.com"
+		user.Profile.Verified = true
+		user.Profile.Attributes = map[string]interface{}{"team": "eng", "phone": "555-0100"}
+		user.Secret.PendingEmail = "alice@new.example.com"
+	})
+	addUser(t, "bob", "secret1", nil)
+	addUser(t, "admin", "secret1", func(user *schema.User) {
+		user.Roles = []string{schema.RoleAdmin}
+	})
+
+	// anonymous callers, and other users, get the public view
+	for _, w := range []*httptest.ResponseRecorder{
+		serve("POST", "/v1/user", `{"user_name": "alice"}`, "", ""),
+		serve("GET", "/v2/users/alice", "", "", ""),
+		serve("GET", "/v1/users/"+alice.ID, "", "", ""),
+		serve("GET", "/v2/users/"+alice.ID, "", "", ""),
+		serve("POST", "/v1/user", `{"user_name": "alice"}`, "bob", "secret1"),
+	} {
+		body := w.Body.String()
+		assert.Equal(t, w.Code, http.StatusOK, body)
+		assert.Equal(t, strings.Contains(body, "example.com"), false, "no email in the public view: "+body)
+		assert.Equal(t, strings.Contains(body, "555-0100"), false, "no private attribute: "+body)
+		assert.Equal(t, strings.Contains(body, `"team":"eng"`), true, "public attributes: "+body)
+	}
+
+	w := serve("POST", "/v1/user", `{"user_name": "alice"}`, "", "")
+	public := decodeBody(t, w)
+	assert.Equal(t, public["user_name"], "alice")
+	assert.Equal(t, public["id"], alice.ID)
+	assert.Equal(t, public["profile"], nil)
+
+	w = serve("POST", "/v1/user", `{"user_name": "alice"}`, "alice", "secret1")
+	self := decodeBody(t, w)
+	profile, _ := self["profile"].(map[string]interface{})
+	assert.Equal(t, profile["email"], "alice@example.com", "self view")
+	assert.Equal(t, self["pending_email"], "alice@new.example.com")
+	assert.Equal(t, self["failed_logins"], nil, "not in the self view")
+	assert.Equal(t, strings.Contains(w.Body.String(), "555-0100"), true, "private attributes")
+
+	w = serve("POST", "/v1/user", `{"user_name": "alice"}`, "admin", "secret1")
+	admin := decodeBody(t, w)
+	profile, _ = admin["profile"].(map[string]interface{})
+	assert.Equal(t, profile["email"], "alice@example.com", "admin view")
+	assert.Equal(t, admin["status"], schema.StatusActive)
+	w = serve("GET", "/v1/admin/users/alice", "", "admin", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = serve("GET", "/v1/admin/users/alice", "", "bob", "secret1")
+	assert.Equal(t, w.Code, http.StatusForbidden, "admins only")
+}
+
+func TestDeletedUserView(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "gone_user", "secret1", func(user *schema.User) {
+		user.Status = schema.StatusDeleted
+	})
+	addUser(t, "admin", "secret1", func(user *schema.User) {
+		user.Roles = []string{schema.RoleAdmin}
+	})
+	w := serve("POST", "/v1/user", `{"user_name": "gone_user"}`, "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound, "hidden from anonymous callers")
+	w = serve("GET", "/v2/users/gone_user", "", "", "")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	w = serve("POST", "/v1/user", `{"user_name": "gone_user"}`, "admin", "secret1")
+	assert.Equal(t, w.Code, http.StatusOK, "seen by admins")
+	assert.Equal(t, decodeBody(t, w)["status"], schema.StatusDeleted)
+}
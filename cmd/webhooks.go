@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// deadLettersHandler lists the webhook events that could not be delivered
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	letters, err := client.ListDeadLetters()
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(letters)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// replayHandler delivers a dead letter again, it is removed on success
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	_, err := client.GetDeadLetter(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	err = webhooks.Replay(id)
+	if err != nil {
+		glog.Warningf("Failed to replay webhook %s: %v", id, err)
+		http.Error(w, "replay failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	useFakeStore(t)
+	addUser(t, "admin", "secret1", func(user *schema.User) {
+		user.Roles = []string{schema.RoleAdmin}
+	})
+	w := serve("POST", "/v1/webhooks/deadletters/missing/replay", "", "admin", "secret1")
+	assert.Equal(t, w.Code, http.StatusNotFound, "no such dead letter")
+
+	letter := webhook.DeadLetter{ID: "gone", URL: "https://hooks.example.com/muser"}
+	assert.Nil(t, client.SaveDeadLetter(&letter))
+	w = serve("POST", "/v1/webhooks/deadletters/gone/replay", "", "admin", "secret1")
+	assert.Equal(t, w.Code, http.StatusBadGateway, "no subscription to deliver to")
+	_, err := client.GetDeadLetter("gone")
+	assert.Nil(t, err, "kept to replay later")
+}
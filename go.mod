@@ -3,7 +3,7 @@ module github.com/codemk8/muser
 go 1.14
 
 require (
-	github.com/asaskevich/govalidator v0.0.0-20180315120708-ccb8e960c48f // indirect
+	github.com/asaskevich/govalidator v0.0.0-20180315120708-ccb8e960c48f
 	github.com/aws/aws-sdk-go v1.25.31
 	github.com/badoux/checkmail v0.0.0-20181210160741-9661bd69e9ad // indirect
 	github.com/go-ozzo/ozzo-validation v3.6.0+incompatible
@@ -12,4 +12,5 @@ require (
 	github.com/gorilla/mux v1.7.3
 	github.com/stretchr/testify v1.5.1
 	golang.org/x/crypto v0.0.0-20191108234033-bd318be0434a
+	golang.org/x/text v0.3.3
 )
@@ -0,0 +1,159 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	// registers the gif decoder
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// MaxPixels bounds the decoded size of uploads, a small file can decode to
+// a huge image. Processing holds a few RGBA copies, about 64MB each at the
+// limit.
+const MaxPixels = 4096 * 4096
+
+var (
+	// ErrUnsupported is returned for content that is not a jpeg, png or gif
+	ErrUnsupported = errors.New("unsupported image type, use jpeg, png or gif")
+	// ErrTooLarge is returned for images of more than MaxPixels
+	ErrTooLarge = errors.New("image dimensions too large")
+)
+
+// decoders are the supported formats by sniffed content type, the
+// extension is image.Decode's name of the format
+var decoders = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// Thumbnail is a square rendition of an avatar
+type Thumbnail struct {
+	Size        int
+	ContentType string
+	// Ext is the file extension, with the dot
+	Ext  string
+	Data []byte
+}
+
+// Sniff returns the content type of an upload from its first bytes,
+// ignoring what the client claims, or ErrUnsupported
+func Sniff(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := decoders[contentType]; !ok {
+		return "", ErrUnsupported
+	}
+	return contentType, nil
+}
+
+// Process decodes an upload and renders it as square thumbnails of the
+// sizes, cropped to the center. The image is re-encoded, which drops its
+// metadata (EXIF, comments, color profiles) after applying its EXIF
+// orientation. Jpeg uploads give jpeg thumbnails, others png.
+func Process(data []byte, sizes []int) ([]Thumbnail, error) {
+	contentType, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != decoders[contentType] {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	src := toRGBA(img)
+	if format == "jpeg" {
+		src = orient(src, orientation(data))
+	}
+	src = cropSquare(src)
+	thumbs := []Thumbnail{}
+	for _, size := range sizes {
+		thumb := Thumbnail{Size: size}
+		buf := bytes.Buffer{}
+		resized := resize(src, size)
+		if format == "jpeg" {
+			thumb.ContentType, thumb.Ext = "image/jpeg", ".jpg"
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			thumb.ContentType, thumb.Ext = "image/png", ".png"
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumb.Data = buf.Bytes()
+		thumbs = append(thumbs, thumb)
+	}
+	return thumbs, nil
+}
+
+// toRGBA copies img into an RGBA image with its origin at 0, 0
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// cropSquare returns the centered square of src
+func cropSquare(src *image.RGBA) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w == h {
+		return src
+	}
+	side := w
+	if h < side {
+		side = h
+	}
+	x, y := (w-side)/2, (h-side)/2
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), src, image.Pt(x, y), draw.Src)
+	return dst
+}
+
+// resize scales a square image to size, averaging the source pixels each
+// target pixel covers
+func resize(src *image.RGBA, size int) *image.RGBA {
+	n := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for dy := 0; dy < size; dy++ {
+		y0, y1 := span(dy, n, size)
+		for dx := 0; dx < size; dx++ {
+			x0, x1 := span(dx, n, size)
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[x*4+c])
+					}
+				}
+			}
+			count := (y1 - y0) * (x1 - x0)
+			i := dy*dst.Stride + dx*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels covered by target pixel i, at least one
+func span(i int, n int, size int) (int, int) {
+	start, end := i*n/size, (i+1)*n/size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
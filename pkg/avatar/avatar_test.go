@@ -0,0 +1,97 @@
+package avatar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// halves is a w x h image, red on the left and blue on the right
+func halves(w int, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+// withExif inserts an EXIF segment with orientation o after the SOI of a
+// jpeg
+func withExif(data []byte, o byte) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, o, 0, 0,
+		0, 0, 0, 0}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := append([]byte{0xff, 0xe1, 0, byte(len(segment) + 2)}, segment...)
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestSniff(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, halves(4, 4))
+	contentType, err := Sniff(buf.Bytes())
+	assert.Equal(t, contentType, "image/png", "png")
+	_, err = Sniff([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"))
+	assert.Equal(t, err, ErrUnsupported, "svg")
+	_, err = Process([]byte("GIF89a\x01"), []int{64})
+	assert.Equal(t, err, ErrUnsupported, "sniffed but not decodable")
+}
+
+func TestProcess(t *testing.T) {
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, halves(300, 200), nil)
+	data := withExif(buf.Bytes(), 6)
+	assert.Equal(t, orientation(data), 6, "exif orientation")
+	assert.Equal(t, bytes.Contains(data, []byte("Exif")), true, "upload has exif")
+
+	thumbs, err := Process(data, []int{128, 32})
+	assert.Equal(t, err, nil, "processed")
+	assert.Equal(t, len(thumbs), 2, "one per size")
+	for _, thumb := range thumbs {
+		assert.Equal(t, thumb.ContentType, "image/jpeg", "jpeg stays jpeg")
+		assert.Equal(t, bytes.Contains(thumb.Data, []byte("Exif")), false, "exif stripped")
+		img, err := jpeg.Decode(bytes.NewReader(thumb.Data))
+		assert.Equal(t, err, nil, "valid jpeg")
+		assert.Equal(t, img.Bounds().Dx(), thumb.Size, "square")
+		assert.Equal(t, img.Bounds().Dy(), thumb.Size, "square")
+		// rotated clockwise the red left half is on top
+		r, _, b, _ := img.At(thumb.Size/2, 2).RGBA()
+		assert.Equal(t, r > b, true, "red on top")
+		r, _, b, _ = img.At(thumb.Size/2, thumb.Size-3).RGBA()
+		assert.Equal(t, b > r, true, "blue at the bottom")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := halves(4, 2)
+	for o, red := range map[int]image.Point{1: {0, 0}, 2: {3, 0}, 3: {3, 1}, 4: {0, 1}, 5: {0, 0}, 6: {1, 0}, 7: {1, 3}, 8: {0, 3}} {
+		dst := orient(src, o)
+		if o >= 5 {
+			assert.Equal(t, dst.Bounds().Size(), image.Pt(2, 4), "swapped dimensions")
+		}
+		assert.Equal(t, dst.RGBAAt(red.X, red.Y), color.RGBA{255, 0, 0, 255}, "red corner")
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8000, 6000)))
+	_, err := Process(buf.Bytes(), []int{64})
+	assert.Equal(t, err, ErrTooLarge, "48 megapixels")
+
+	buf.Reset()
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4097, 4096)))
+	_, err = Process(buf.Bytes(), []int{64})
+	assert.Equal(t, err, ErrTooLarge, "one column over the limit")
+}
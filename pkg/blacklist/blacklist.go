@@ -0,0 +1,210 @@
+// Package blacklist matches user names against reserved names and patterns.
+//
+// A pattern is either
+//   - a name, e.g. "admin", matched exactly
+//   - a glob, e.g. "admin*", if it has any of "*?[", see path.Match
+//   - a regular expression prefixed by "re:", e.g. "re:^support[0-9]+$"
+//
+// Matching is case insensitive and sees through the usual disguises: leet
+// digits and symbols ("g00gle", "4dm1n") and trailing digits ("admin1").
+// Names shorter than minFoldLen, e.g. "me" or "go", are only matched exactly,
+// their disguises ("me123", "go2020") are mostly ordinary names.
+package blacklist
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const regexpPrefix = "re:"
+
+// minFoldLen is the length of the shortest names matched through disguises
+const minFoldLen = 4
+
+// leet folds the characters commonly standing for letters, "1" stands for
+// both "i" and "l" and is folded by fold
+var leet = strings.NewReplacer("0", "o", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "@", "a", "$", "s", "!", "i")
+
+// fold returns the forms of name matched against the patterns
+func fold(name string) []string {
+	lower := strings.ToLower(name)
+	forms := []string{}
+	seen := map[string]bool{}
+	add := func(form string) {
+		if form != "" && !seen[form] {
+			seen[form] = true
+			forms = append(forms, form)
+		}
+	}
+	for _, s := range []string{lower, strings.TrimRight(lower, "0123456789")} {
+		add(s)
+		folded := leet.Replace(s)
+		add(strings.Replace(folded, "1", "i", -1))
+		add(strings.Replace(folded, "1", "l", -1))
+	}
+	return forms
+}
+
+// Matcher is a compiled list of patterns
+type Matcher struct {
+	exact map[string]bool
+	// short are the exact names matched without folding
+	short   map[string]bool
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+// Compile parses patterns, empty ones are skipped
+func Compile(patterns []string) (*Matcher, error) {
+	m := &Matcher{exact: map[string]bool{}, short: map[string]bool{}}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+		case strings.HasPrefix(p, regexpPrefix):
+			re, err := regexp.Compile("(?i)" + strings.TrimPrefix(p, regexpPrefix))
+			if err != nil {
+				return nil, err
+			}
+			m.regexps = append(m.regexps, re)
+		case strings.ContainsAny(p, "*?["):
+			p = strings.ToLower(p)
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, err
+			}
+			m.globs = append(m.globs, p)
+		case len([]rune(p)) < minFoldLen:
+			m.short[strings.ToLower(p)] = true
+		default:
+			m.exact[strings.ToLower(p)] = true
+		}
+	}
+	return m, nil
+}
+
+// Match tells if name, or a disguise of it, matches any pattern
+func (m *Matcher) Match(name string) bool {
+	if m == nil {
+		return false
+	}
+	if m.short[strings.ToLower(name)] {
+		return true
+	}
+	for _, form := range fold(name) {
+		if m.exact[form] {
+			return true
+		}
+		for _, glob := range m.globs {
+			if ok, _ := path.Match(glob, form); ok {
+				return true
+			}
+		}
+		for _, re := range m.regexps {
+			if re.MatchString(form) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Blacklist merges the built-in names with the patterns of a file and of
+// the store, either can be replaced at runtime
+type Blacklist struct {
+	mu      sync.RWMutex
+	builtin *Matcher
+	file    *Matcher
+	store   *Matcher
+}
+
+// New returns a blacklist of the built-in names
+func New(builtin []string) *Blacklist {
+	m, err := Compile(builtin)
+	if err != nil {
+		// built-in names are not patterns
+		panic(err)
+	}
+	return &Blacklist{builtin: m}
+}
+
+// Match tells if name is blacklisted
+func (b *Blacklist) Match(name string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.builtin.Match(name) || b.file.Match(name) || b.store.Match(name)
+}
+
+// SetStore replaces the patterns kept in the store
+func (b *Blacklist) SetStore(patterns []string) error {
+	m, err := Compile(patterns)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.store = m
+	b.mu.Unlock()
+	return nil
+}
+
+// ReadFile reads the patterns of a file, one per line, "#" starts a comment
+func ReadFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	patterns := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// LoadFile replaces the patterns of the file
+func (b *Blacklist) LoadFile(name string) error {
+	patterns, err := ReadFile(name)
+	if err != nil {
+		return err
+	}
+	m, err := Compile(patterns)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.file = m
+	b.mu.Unlock()
+	return nil
+}
+
+// WatchFile reloads the file whenever it is modified, checking every
+// period. A file failing to load keeps the previous patterns.
+func (b *Blacklist) WatchFile(name string, period time.Duration) {
+	var modified time.Time
+	if info, err := os.Stat(name); err == nil {
+		modified = info.ModTime()
+	}
+	for range time.Tick(period) {
+		info, err := os.Stat(name)
+		if err != nil || !info.ModTime().After(modified) {
+			continue
+		}
+		modified = info.ModTime()
+		err = b.LoadFile(name)
+		if err != nil {
+			glog.Warningf("Failed to reload blacklist %s: %v", name, err)
+			continue
+		}
+		glog.Infof("Reloaded blacklist %s", name)
+	}
+}
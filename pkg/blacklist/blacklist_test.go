@@ -0,0 +1,54 @@
+package blacklist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcher(t *testing.T) {
+	m, err := Compile([]string{"admin", "google", "support*", "re:^root[0-9]*$", "me", "go", ""})
+	assert.Nil(t, err)
+	assert.Equal(t, m.Match("admin"), true)
+	assert.Equal(t, m.Match("Admin"), true, "case insensitive")
+	assert.Equal(t, m.Match("admin1"), true, "trailing digits")
+	assert.Equal(t, m.Match("4dm1n"), true, "leet")
+	assert.Equal(t, m.Match("g00gle"), true, "leet")
+	assert.Equal(t, m.Match("goog1e"), true, "1 for l")
+	assert.Equal(t, m.Match("Support_Team"), true, "glob")
+	assert.Equal(t, m.Match("ROOT42"), true, "regexp")
+	assert.Equal(t, m.Match("administrator"), false, "exact names are exact")
+	assert.Equal(t, m.Match("normal_user"), false)
+	assert.Equal(t, m.Match("Me"), true, "short names are matched exactly")
+	assert.Equal(t, m.Match("me123"), false, "short names keep their digits")
+	assert.Equal(t, m.Match("go2020"), false, "short names keep their digits")
+	assert.Equal(t, m.Match("g0"), false, "short names are not folded")
+
+	_, err = Compile([]string{"re:("})
+	assert.NotNil(t, err, "bad regexp")
+	_, err = Compile([]string{"[a"})
+	assert.NotNil(t, err, "bad glob")
+}
+
+func TestBlacklist(t *testing.T) {
+	b := New([]string{"admin"})
+	assert.Equal(t, b.Match("admin"), true, "built-in")
+	assert.Equal(t, b.Match("acme"), false)
+
+	dir, err := ioutil.TempDir("", "blacklist")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "blacklist.txt")
+	err = ioutil.WriteFile(name, []byte("# reserved\nacme*\n\n"), 0644)
+	assert.Nil(t, err)
+	assert.Nil(t, b.LoadFile(name))
+	assert.Equal(t, b.Match("acme_corp"), true, "from the file")
+
+	assert.Nil(t, b.SetStore([]string{"re:^evil"}))
+	assert.Equal(t, b.Match("evil_twin"), true, "from the store")
+	assert.NotNil(t, b.SetStore([]string{"re:("}))
+	assert.Equal(t, b.Match("evil_twin"), true, "a bad update keeps the patterns")
+}
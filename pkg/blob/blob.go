@@ -0,0 +1,64 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Store keeps blobs, such as avatars, under slash separated keys and
+// serves them at a URL
+type Store interface {
+	Put(key string, contentType string, data []byte) error
+	Delete(key string) error
+	// URL is where clients download the blob of key
+	URL(key string) string
+}
+
+// Config selects and configures a Store
+type Config struct {
+	// Kind is "local", the only store for now
+	Kind string
+	// Dir the local store writes to
+	Dir string
+	// BaseURL the blobs are served under
+	BaseURL string
+}
+
+// ErrBadKey is returned for keys escaping the store
+var ErrBadKey = errors.New("bad blob key")
+
+// NewStore creates the store selected by the config
+func NewStore(cfg Config) (Store, error) {
+	switch cfg.Kind {
+	case "local", "":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("local blob store needs a directory")
+		}
+		return NewLocal(cfg.Dir, cfg.BaseURL)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.Kind)
+	}
+}
+
+// KeyOf returns the key of a URL of the store, or "" if the URL is not one
+func KeyOf(store Store, url string) string {
+	base := store.URL("")
+	if url == "" || !strings.HasPrefix(url, base) {
+		return ""
+	}
+	return strings.TrimPrefix(url, base)
+}
+
+// validKey rejects empty keys and keys with empty, "." or ".." segments
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, "\\") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores blobs as files under a directory, which the server exposes
+// at BaseURL
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocal creates the directory of a local store if needed
+func NewLocal(dir string, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/") + "/"}, nil
+}
+
+func (local *Local) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrBadKey
+	}
+	return filepath.Join(local.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob through a temporary file so readers never see a
+// partial one, the content type is told by the extension of the key
+func (local *Local) Put(key string, contentType string, data []byte) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Delete removes the blob, deleting a missing blob is not an error
+func (local *Local) Delete(key string) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// URL returns BaseURL followed by the key
+func (local *Local) URL(key string) string {
+	return local.BaseURL + key
+}
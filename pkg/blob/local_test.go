@@ -0,0 +1,39 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blob")
+	assert.Equal(t, err, nil, "temp dir")
+	defer os.RemoveAll(dir)
+
+	store, err := NewStore(Config{Dir: dir, BaseURL: "/v1/avatars"})
+	assert.Equal(t, err, nil, "local by default")
+	err = store.Put("acme/42/a.png", "image/png", []byte("png"))
+	assert.Equal(t, err, nil, "put")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "acme", "42", "a.png"))
+	assert.Equal(t, string(data), "png", "written under the directory")
+
+	url := store.URL("acme/42/a.png")
+	assert.Equal(t, url, "/v1/avatars/acme/42/a.png", "served under the base url")
+	assert.Equal(t, KeyOf(store, url), "acme/42/a.png", "key of the url")
+	assert.Equal(t, KeyOf(store, "https://example.com/a.png"), "", "not a url of the store")
+
+	assert.Equal(t, store.Delete("acme/42/a.png"), nil, "delete")
+	assert.Equal(t, store.Delete("acme/42/a.png"), nil, "delete again")
+	_, err = os.Stat(filepath.Join(dir, "acme", "42", "a.png"))
+	assert.Equal(t, os.IsNotExist(err), true, "deleted")
+
+	for _, key := range []string{"", "../a.png", "acme/../../a.png", "/a.png", "acme//a.png", `acme\a.png`} {
+		assert.Equal(t, store.Put(key, "image/png", []byte("png")), ErrBadKey, key)
+	}
+	_, err = NewStore(Config{Kind: "s3", Dir: dir})
+	assert.NotEqual(t, err, nil, "unknown store")
+}
@@ -0,0 +1,134 @@
+package dynamo
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+// updateUser applies an update expression to an existing user, or returns
+// ErrNotFound
+func (client DynamoClient) updateUser(username string, update string, values map[string]*dynamodb.AttributeValue) error {
+	update, values = bumpVersion(update, values)
+	input := &dynamodb.UpdateItemInput{
+		Key:                       client.keyAttr(username),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(user_name)"),
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(client.table),
+	}
+	if strings.Contains(update, "#s") {
+		// status is a reserved word
+		input.ExpressionAttributeNames = map[string]*string{"#s": aws.String("status")}
+	}
+	_, err := client.svc.UpdateItem(input)
+	if isConditionFailed(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error updating user %s: %v", username, err)
+	}
+	return err
+}
+
+// SetRoles replaces the roles of a user
+func (client DynamoClient) SetRoles(username string, roles []string) error {
+	if len(roles) == 0 {
+		return client.updateUser(username, "REMOVE roles", nil)
+	}
+	return client.updateUser(username, "SET roles = :r", map[string]*dynamodb.AttributeValue{
+		":r": {SS: aws.StringSlice(roles)},
+	})
+}
+
+// ForcePasswordReset blocks authentication until the password is reset with
+// the token, the notification sending the token is written in the same
+// transaction if there is one
+func (client DynamoClient) ForcePasswordReset(username string, tokenHash string, expiry int64, note *verify.VerifyRequest) error {
+	update, values := bumpVersion("SET secret.must_reset = :t, secret.reset_token = :h, secret.reset_expiry = :e",
+		map[string]*dynamodb.AttributeValue{
+			":t": {BOOL: aws.Bool(true)},
+			":h": {S: aws.String(tokenHash)},
+			":e": {N: aws.String(strconv.FormatInt(expiry, 10))},
+		})
+	items := []*dynamodb.TransactWriteItem{{Update: &dynamodb.Update{
+		Key:                       client.keyAttr(username),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(user_name)"),
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(client.table),
+	}}}
+	if note != nil {
+		outbox, err := client.outboxPuts([]verify.VerifyRequest{*note})
+		if err != nil {
+			return err
+		}
+		items = append(items, outbox...)
+	}
+	_, err := client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if isTransactionCanceled(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error forcing password reset of %s: %v", username, err)
+	}
+	return err
+}
+
+// ResetPassword sets a new password hash and clears the reset token
+func (client DynamoClient) ResetPassword(username string, hash string) error {
+	return client.updateUser(username, "SET secret.salt = :p REMOVE secret.must_reset, secret.reset_token, secret.reset_expiry",
+		map[string]*dynamodb.AttributeValue{
+			":p": {S: aws.String(hash)},
+		})
+}
+
+// ForceVerifyEmail marks the email of the user verified, with the
+// notifications of the change. A pending email is promoted by the caller,
+// which tells the previous email, whose claim is kept for a revert.
+func (client DynamoClient) ForceVerifyEmail(user *schema.User, notes ...verify.VerifyRequest) error {
+	user.Profile.Verified = true
+	user.Secret.ClearVerifyCode()
+	user.Secret.ClearVerifyAttempts()
+	return client.ChangeEmail(user, "", notes...)
+}
+
+// DeleteUser removes a user, its group memberships, its email claim and its
+// ID reference
+func (client DynamoClient) DeleteUser(user *schema.User) error {
+	for _, group := range user.Groups {
+		err := client.RemoveMember(group, user.UserName)
+		if err != nil {
+			return err
+		}
+	}
+	items := []*dynamodb.TransactWriteItem{{Delete: &dynamodb.Delete{
+		Key:       client.keyAttr(user.UserName),
+		TableName: aws.String(client.table),
+	}}}
+	if user.Profile.Email != "" {
+		release, err := client.releaseOwned(user.Profile.Email, user.UserName)
+		if err != nil {
+			return err
+		}
+		if release != nil {
+			items = append(items, release)
+		}
+	}
+	if user.ID != "" {
+		items = append(items, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			Key:       client.recordItemKey(kindID, user.ID),
+			TableName: aws.String(client.table),
+		}})
+	}
+	_, err := client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		glog.Warningf("Error deleting user %s: %v", user.UserName, err)
+	}
+	return err
+}
@@ -1,5 +1,20 @@
 package dynamo
 
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/codemk8/muser/pkg/blacklist"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+// Blacklist are the names reserved in every tenant, matched case
+// insensitively and through disguises, see package blacklist
+
 var Blacklist = [...]string{".htaccess", ".htpasswd", ".well-known", "400", "401", "403", "404", "405", "406", "407", "408", "409",
 	"410", "411", "412", "413", "414", "415", "416", "417", "421", "422", "423", "424", "426", "428", "429", "431", "500",
 	"501", "502", "503", "504", "505", "506", "507", "508", "509", "510", "511", "_domainkey", "about", "about-us", "abuse",
@@ -52,3 +67,111 @@ func NewBlackListMap() map[string]bool {
 	}
 	return set
 }
+
+const kindBlacklist = "blacklist"
+
+// kindBlacklistVersion is the record counting the changes of the blacklist
+// entries, refreshes read it and only list the entries when it changed
+const kindBlacklistVersion = "blacklist_version"
+
+// blacklistSettle is how long a change takes to reach kindIndex, refreshes
+// within it reload the entries until the change is surely in them
+const blacklistSettle = 10 * time.Second
+
+// blacklistVersion is the version record
+type blacklistVersion struct {
+	Version int64 `json:"version"`
+	// Changed is the unix time of the last change
+	Changed int64 `json:"changed"`
+}
+
+// Blacklist returns the blacklist shared by the clients of all tenants
+func (client DynamoClient) Blacklist() *blacklist.Blacklist {
+	return client.blacklist
+}
+
+// ListBlacklist returns the blacklist entries kept in the store
+func (client DynamoClient) ListBlacklist() ([]schema.BlacklistEntry, error) {
+	entries := []schema.BlacklistEntry{}
+	err := client.queryRecords(kindBlacklist, &entries)
+	return entries, err
+}
+
+// bumpBlacklist is the transaction item counting a change of the entries
+func (client DynamoClient) bumpBlacklist() *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+		Key:              client.recordItemKey(kindBlacklistVersion, "entries"),
+		UpdateExpression: aws.String("SET kind = :kind, changed = :now ADD version :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":kind": {S: aws.String(kindBlacklistVersion)},
+			":now":  {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+			":one":  {N: aws.String("1")},
+		},
+		TableName: aws.String(client.table),
+	}}
+}
+
+// AddBlacklist stores an entry, overwriting the same pattern
+func (client DynamoClient) AddBlacklist(entry *schema.BlacklistEntry) error {
+	item, err := client.recordItem(kindBlacklist, entry.Pattern, entry)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{Item: item, TableName: aws.String(client.table)}},
+		client.bumpBlacklist(),
+	}})
+	if err != nil {
+		glog.Warningf("Error adding %s to the blacklist: %v", entry.Pattern, err)
+	}
+	return err
+}
+
+// RemoveBlacklist deletes the entry of a pattern, or returns ErrNotFound
+func (client DynamoClient) RemoveBlacklist(pattern string) error {
+	_, err := client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+		{Delete: &dynamodb.Delete{
+			Key:                 client.recordItemKey(kindBlacklist, pattern),
+			ConditionExpression: aws.String("attribute_exists(user_name)"),
+			TableName:           aws.String(client.table),
+		}},
+		client.bumpBlacklist(),
+	}})
+	if canceledAt(err) == 0 {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error removing %s from the blacklist: %v", pattern, err)
+	}
+	return err
+}
+
+// RefreshBlacklist loads the entries of the store into the blacklist, unless
+// they did not change since the last refresh
+func (client DynamoClient) RefreshBlacklist() error {
+	version := blacklistVersion{}
+	err := client.getRecord(kindBlacklistVersion, "entries", &version)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if atomic.LoadInt64(client.blacklistLoaded) == version.Version {
+		return nil
+	}
+	entries, err := client.ListBlacklist()
+	if err != nil {
+		return err
+	}
+	patterns := make([]string, len(entries))
+	for i, entry := range entries {
+		patterns[i] = entry.Pattern
+	}
+	err = client.blacklist.SetStore(patterns)
+	if err != nil {
+		return err
+	}
+	if time.Since(time.Unix(version.Changed, 0)) < blacklistSettle {
+		return nil
+	}
+	atomic.StoreInt64(client.blacklistLoaded, version.Version)
+	return nil
+}
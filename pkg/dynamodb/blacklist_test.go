@@ -3,6 +3,7 @@ package dynamo
 import (
 	"testing"
 
+	"github.com/codemk8/muser/pkg/blacklist"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,3 +14,18 @@ func TestNewBlackListMap(t *testing.T) {
 	assert.Equal(t, blacklist["google"], true, "company name in the end")
 	assert.Equal(t, blacklist["geforce"], true, "the last one?")
 }
+
+func TestBadUserName(t *testing.T) {
+	client := DynamoClient{blacklist: blacklist.New(Blacklist[:])}
+	assert.Equal(t, client.BadUserName("Google"), true, "case insensitive")
+	assert.Equal(t, client.BadUserName("adm1n"), true, "leet")
+	assert.Equal(t, client.BadUserName("admin2"), true, "trailing digits")
+	assert.Equal(t, client.BadUserName("normal_user"), false)
+}
+
+func TestConfusable(t *testing.T) {
+	client := DynamoClient{blacklist: blacklist.New(Blacklist[:])}
+	assert.Equal(t, client.Confusable("мар"), true, "Cyrillic passing for map")
+	assert.Equal(t, client.Confusable("иван"), false, "not passing for a Latin name")
+	assert.Equal(t, client.Confusable("map"), false, "Latin names are checked as they are")
+}
@@ -3,21 +3,33 @@ package dynamo
 import (
 	"errors"
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/codemk8/muser/pkg/blacklist"
 	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
 	"github.com/golang/glog"
 )
 
 type DynamoClient struct {
-	table     string
-	svc       *dynamodb.DynamoDB
-	blacklist map[string]bool
+	table string
+	svc   dynamodbiface.DynamoDBAPI
+	// shared by the clients of all tenants so reloads reach them all
+	blacklist *blacklist.Blacklist
+	// version of the blacklist entries loaded, -1 before the first load
+	blacklistLoaded *int64
+	// tenant the client is scoped to, see ForTenant
+	tenant          string
+	tenantBlacklist *blacklist.Matcher
+	// seals the secrets of outbox entries, see SetSealer
+	sealer *verify.Sealer
 }
 
 // NewClient starts a new client
@@ -28,8 +40,11 @@ func NewClient(table string, region string) (*DynamoClient, error) {
 	sess := session.Must(session.NewSession(awscfg))
 
 	// Create the DynamoDB service client to make the query request with.
-	svc := dynamodb.New(sess)
+	return NewClientFor(dynamodb.New(sess), table)
+}
 
+// NewClientFor starts a new client of the table served by svc
+func NewClientFor(svc dynamodbiface.DynamoDBAPI, table string) (*DynamoClient, error) {
 	params := &dynamodb.ScanInput{
 		TableName: aws.String(table),
 		Limit:     aws.Int64(1), // limit for quick return
@@ -49,39 +64,104 @@ func NewClient(table string, region string) (*DynamoClient, error) {
 		return nil, err
 	}
 	// fmt.Printf("Query %d items in the table.\n", len(items))
-	return &DynamoClient{table: table, svc: svc, blacklist: NewBlackListMap()}, nil
+	loaded := int64(-1)
+	return &DynamoClient{table: table, svc: svc, blacklist: blacklist.New(Blacklist[:]), blacklistLoaded: &loaded}, nil
 }
 
 func (client DynamoClient) UserExist(user string) bool {
-	item, err := client.GetUser(user, false)
-	if err != nil {
+	_, err := client.GetUser(user, false)
+	return err == nil
+}
+
+func (client DynamoClient) BadUserName(username string) bool {
+	// "#" prefixed keys are reserved for records, "/" separates tenants
+	if strings.HasPrefix(username, "#") || strings.Contains(username, "/") {
+		return true
+	}
+	return client.blacklist.Match(username) || client.tenantBlacklist.Match(username)
+}
+
+// Confusable tells if a canonical name passes for a blacklisted, taken or
+// reserved Latin name, see schema.LatinSkeleton
+func (client DynamoClient) Confusable(username string) bool {
+	skeleton, ok := schema.LatinSkeleton(username)
+	if !ok || skeleton == username {
 		return false
 	}
-	return item.UserName == user
+	return client.BadUserName(skeleton) || client.UserExist(skeleton) || client.Reserved(skeleton)
 }
 
-func (client DynamoClient) BadUserName(username string) bool {
-	return client.blacklist[username]
+// userAttributes are the top level attributes of a user item besides secret
+var userAttributes = []string{"user_name", "id", "display_name", "created", "profile", "roles", "groups", "invite_quota",
+	"status", "status_reason", "status_changed", "status_until", "deleted_at", "restore_status", "restore_reason",
+	"name_history", "version"}
+
+// userProjection selects the user attributes, with the secret or not
+func userProjection(getSecret bool) expression.ProjectionBuilder {
+	proj := expression.NamesList(expression.Name(userAttributes[0]))
+	for _, name := range userAttributes[1:] {
+		proj = proj.AddNames(expression.Name(name))
+	}
+	if getSecret {
+		proj = proj.AddNames(expression.Name("secret"))
+	}
+	return proj
+}
+
+// errUserNotFound is returned when no user has the key
+var errUserNotFound = errors.New("user not found")
+
+// ErrConflict is returned when writing a user that changed since it was read
+var ErrConflict = errors.New("the user changed meanwhile")
+
+// versionCondition is the condition of a write replacing the user as read,
+// it fails if the user is gone or was written since
+func versionCondition(user *schema.User) (string, map[string]*dynamodb.AttributeValue) {
+	if user.Version == 0 {
+		// never written since versions
+		return "attribute_exists(user_name) AND attribute_not_exists(version)", nil
+	}
+	return "attribute_exists(user_name) AND version = :version", map[string]*dynamodb.AttributeValue{
+		":version": {N: aws.String(strconv.FormatInt(user.Version, 10))},
+	}
 }
 
-// GetUser returns a user in the table, if the user does not exist,
-// it does not return error, only the key is empty (UserName)
+// bumpVersion adds the increment of the version to the update expression of
+// a user, so that writes of the user as read before fail
+func bumpVersion(update string, values map[string]*dynamodb.AttributeValue) (string, map[string]*dynamodb.AttributeValue) {
+	if values == nil {
+		values = map[string]*dynamodb.AttributeValue{}
+	}
+	values[":one"] = &dynamodb.AttributeValue{N: aws.String("1")}
+	if i := strings.Index(update, "ADD "); i >= 0 {
+		return update[:i+4] + "version :one, " + update[i+4:], values
+	}
+	return update + " ADD version :one", values
+}
+
+// GetUser returns a user in the table by its exact key, or else by the
+// canonical form of its name
 func (client DynamoClient) GetUser(user string, getSecret bool) (*schema.User, error) {
-	keyCond := expression.Key("user_name").Equal(expression.Value(user))
-	var proj expression.ProjectionBuilder
-	if getSecret {
-		proj = expression.NamesList(expression.Name("user_name"),
-			expression.Name("created"),
-			expression.Name("profile"),
-			expression.Name("secret"))
-	} else {
-		proj = expression.NamesList(expression.Name("user_name"),
-			expression.Name("created"),
-			expression.Name("profile"))
-	}
-	var expr expression.Expression
-	var err error
-	expr, err = expression.NewBuilder().WithKeyCondition(keyCond).WithProjection(proj).Build()
+	if strings.HasPrefix(user, "#") || strings.Contains(user, "/") {
+		return nil, errUserNotFound
+	}
+	// the exact key first, a legacy "Alice" must not be shadowed by "alice"
+	found, err := client.queryUser(user, getSecret)
+	if found != nil {
+		return found, err
+	}
+	canonical, cerr := schema.CanonicalUserName(user)
+	if cerr != nil || canonical == user {
+		return found, err
+	}
+	return client.queryUser(canonical, getSecret)
+}
+
+// queryUser returns the user whose key is user
+func (client DynamoClient) queryUser(user string, getSecret bool) (*schema.User, error) {
+	keyCond := expression.Key("user_name").Equal(expression.Value(client.key(user)))
+	proj := userProjection(getSecret)
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithProjection(proj).Build()
 	if err != nil {
 		glog.Warningf("failed to create the expression, %v", err)
 		return nil, err
@@ -104,7 +184,7 @@ func (client DynamoClient) GetUser(user string, getSecret bool) (*schema.User, e
 	}
 	users := []schema.User{}
 	if len(result.Items) == 0 {
-		return nil, errors.New("user not found")
+		return nil, errUserNotFound
 	}
 	// items := Project{}
 	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &users)
@@ -112,6 +192,8 @@ func (client DynamoClient) GetUser(user string, getSecret bool) (*schema.User, e
 		glog.Warningf("Failed to unmarshal Record, %v", err)
 		return nil, err
 	}
+	// the key is qualified by the tenant
+	users[0].UserName = user
 	return &users[0], nil
 }
 
@@ -121,38 +203,26 @@ func convertAttrib(user *schema.User) (map[string]*dynamodb.AttributeValue, erro
 	return map[string]*dynamodb.AttributeValue{"object": av}, err
 }
 
+// AddNewUser writes the user as read, it fails with ErrConflict if the user
+// changed since
 func (client DynamoClient) AddNewUser(user *schema.User) error {
-	profile, err := dynamodbattribute.MarshalMap(user.Profile)
+	item, err := client.userItem(user)
 	if err != nil {
-		glog.Warningf("Error mashal profile %v", err)
 		return err
 	}
-	secret, err := dynamodbattribute.MarshalMap(user.Secret)
-	if err != nil {
-		glog.Warningf("Error mashal secret %v", err)
-		return err
-	}
-
+	cond, values := versionCondition(user)
 	input := &dynamodb.PutItemInput{
-		Item: map[string]*dynamodb.AttributeValue{
-			"user_name": {
-				S: aws.String(user.UserName),
-			},
-			"created": {
-				N: aws.String(strconv.FormatInt(user.Created, 10)),
-			},
-			"profile": {
-				M: profile,
-			},
-			"secret": {
-				M: secret,
-			},
-		},
-		ReturnConsumedCapacity: aws.String("TOTAL"),
-		TableName:              aws.String(client.table),
+		Item:                      item,
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeValues: values,
+		ReturnConsumedCapacity:    aws.String("TOTAL"),
+		TableName:                 aws.String(client.table),
 	}
 
 	_, err = client.svc.PutItem(input)
+	if isConditionFailed(err) {
+		return ErrConflict
+	}
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			glog.Warningf("dynamodb put item error type %s: %v", aerr.Code(), aerr)
@@ -163,25 +233,100 @@ func (client DynamoClient) AddNewUser(user *schema.User) error {
 		}
 		return err
 	}
+	user.Version++
 	return nil
 }
 
+// userItem converts a user to the item stored in the table, at the version
+// following the one read
+func (client DynamoClient) userItem(user *schema.User) (map[string]*dynamodb.AttributeValue, error) {
+	profile, err := dynamodbattribute.MarshalMap(user.Profile)
+	if err != nil {
+		glog.Warningf("Error mashal profile %v", err)
+		return nil, err
+	}
+	secret, err := dynamodbattribute.MarshalMap(user.Secret)
+	if err != nil {
+		glog.Warningf("Error mashal secret %v", err)
+		return nil, err
+	}
+	item := map[string]*dynamodb.AttributeValue{
+		"user_name": {
+			S: aws.String(client.key(user.UserName)),
+		},
+		"created": {
+			N: aws.String(strconv.FormatInt(user.Created, 10)),
+		},
+		"profile": {
+			M: profile,
+		},
+		"secret": {
+			M: secret,
+		},
+		"version": {
+			N: aws.String(strconv.FormatInt(user.Version+1, 10)),
+		},
+	}
+	if len(user.Roles) > 0 {
+		item["roles"] = &dynamodb.AttributeValue{SS: aws.StringSlice(user.Roles)}
+	}
+	if len(user.Groups) > 0 {
+		item["groups"] = &dynamodb.AttributeValue{SS: aws.StringSlice(user.Groups)}
+	}
+	if user.InviteQuota > 0 {
+		item["invite_quota"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(user.InviteQuota))}
+	}
+	if user.Status != "" {
+		item["status"] = &dynamodb.AttributeValue{S: aws.String(user.Status)}
+	}
+	if user.StatusReason != "" {
+		item["status_reason"] = &dynamodb.AttributeValue{S: aws.String(user.StatusReason)}
+	}
+	if user.ID != "" {
+		item["id"] = &dynamodb.AttributeValue{S: aws.String(user.ID)}
+	}
+	if user.DisplayName != "" {
+		item["display_name"] = &dynamodb.AttributeValue{S: aws.String(user.DisplayName)}
+	}
+	if user.StatusChanged > 0 {
+		item["status_changed"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(user.StatusChanged, 10))}
+	}
+	if user.StatusUntil > 0 {
+		item["status_until"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(user.StatusUntil, 10))}
+	}
+	if user.DeletedAt > 0 {
+		item["deleted_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(user.DeletedAt, 10))}
+	}
+	if user.RestoreStatus != "" {
+		item["restore_status"] = &dynamodb.AttributeValue{S: aws.String(user.RestoreStatus)}
+	}
+	if user.RestoreReason != "" {
+		item["restore_reason"] = &dynamodb.AttributeValue{S: aws.String(user.RestoreReason)}
+	}
+	if len(user.NameHistory) > 0 {
+		history, err := dynamodbattribute.Marshal(user.NameHistory)
+		if err != nil {
+			return nil, err
+		}
+		item["name_history"] = history
+	}
+	client.tagTenant(item)
+	return item, nil
+}
+
 // UpdateUserPass updates the user password
 func (client DynamoClient) UpdateUserPass(user *schema.User) error {
-	input := &dynamodb.UpdateItemInput{
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":p": {
-				S: aws.String(user.Secret.Salt),
-			},
+	update, values := bumpVersion("SET secret.salt = :p", map[string]*dynamodb.AttributeValue{
+		":p": {
+			S: aws.String(user.Secret.Salt),
 		},
+	})
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: values,
 
-		Key: map[string]*dynamodb.AttributeValue{
-			"user_name": {
-				S: aws.String(user.UserName),
-			},
-		},
+		Key:              client.keyAttr(user.UserName),
 		ReturnValues:     aws.String("UPDATED_NEW"),
-		UpdateExpression: aws.String("SET secret.salt = :p"),
+		UpdateExpression: aws.String(update),
 		TableName:        aws.String(client.table),
 	}
 
@@ -0,0 +1,125 @@
+package dynamo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/golang/glog"
+)
+
+// ErrBadCursor is returned for cursors not produced by this package
+var ErrBadCursor = errors.New("bad cursor")
+
+// encodeCursor turns the LastEvaluatedKey of a scan or query into an opaque string,
+// empty when there are no more pages
+func encodeCursor(key map[string]*dynamodb.AttributeValue) string {
+	if len(key) == 0 {
+		return ""
+	}
+	values := map[string]string{}
+	for name, v := range key {
+		values[name] = aws.StringValue(v.S)
+	}
+	b, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor turns a cursor back into an ExclusiveStartKey
+func decodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	values := map[string]string{}
+	err = json.Unmarshal(b, &values)
+	if err != nil || len(values) == 0 {
+		return nil, ErrBadCursor
+	}
+	key := map[string]*dynamodb.AttributeValue{}
+	for name, v := range values {
+		key[name] = &dynamodb.AttributeValue{S: aws.String(v)}
+	}
+	return key, nil
+}
+
+// maxPageReads bounds the requests made for one page, a page of a sparse
+// filter may come back short with a cursor to continue from
+const maxPageReads = 10
+
+// scanPage returns up to limit items of the client's tenant matching filter
+// starting at cursor, and the cursor of the next page. DynamoDB applies
+// Limit before the filter, so the scan continues until the page is full,
+// the table ends or maxPageReads requests were made.
+func (client DynamoClient) scanPage(filter expression.ConditionBuilder, proj *expression.ProjectionBuilder,
+	cursor string, limit int) ([]map[string]*dynamodb.AttributeValue, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	builder := expression.NewBuilder().WithFilter(filter.And(client.tenantFilter()))
+	if proj != nil {
+		builder = builder.WithProjection(*proj)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, "", err
+	}
+	items := []map[string]*dynamodb.AttributeValue{}
+	for reads := 1; ; reads++ {
+		input := &dynamodb.ScanInput{
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			FilterExpression:          expr.Filter(),
+			ProjectionExpression:      expr.Projection(),
+			ExclusiveStartKey:         startKey,
+			Limit:                     aws.Int64(int64(limit - len(items))),
+			TableName:                 aws.String(client.table),
+		}
+		result, err := client.svc.Scan(input)
+		if err != nil {
+			glog.Warningf("Error scanning: %v", err)
+			return nil, "", err
+		}
+		items = append(items, result.Items...)
+		startKey = result.LastEvaluatedKey
+		if len(startKey) == 0 || len(items) >= limit || reads >= maxPageReads {
+			return items, encodeCursor(startKey), nil
+		}
+	}
+}
+
+// queryPage is scanPage for the records of a kind whose id starts with
+// prefix, read through kindIndex
+func (client DynamoClient) queryPage(kind string, prefix string, cond *expression.ConditionBuilder,
+	cursor string, limit int) ([]map[string]*dynamodb.AttributeValue, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	input, err := client.recordsQuery(kind, prefix, cond)
+	if err != nil {
+		return nil, "", err
+	}
+	items := []map[string]*dynamodb.AttributeValue{}
+	for reads := 1; ; reads++ {
+		input.ExclusiveStartKey = startKey
+		input.Limit = aws.Int64(int64(limit - len(items)))
+		result, err := client.svc.Query(input)
+		if err != nil {
+			glog.Warningf("Error querying %s records: %v", kind, err)
+			return nil, "", err
+		}
+		items = append(items, result.Items...)
+		startKey = result.LastEvaluatedKey
+		if len(startKey) == 0 || len(items) >= limit || reads >= maxPageReads {
+			return items, encodeCursor(startKey), nil
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package dynamo
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	key := map[string]*dynamodb.AttributeValue{"user_name": {S: aws.String("#member#eng#test_user")}}
+	cursor := encodeCursor(key)
+	decoded, err := decodeCursor(cursor)
+	assert.Nil(t, err)
+	assert.Equal(t, aws.StringValue(decoded["user_name"].S), "#member#eng#test_user", "round trip")
+
+	assert.Equal(t, encodeCursor(nil), "", "no more pages")
+	decoded, err = decodeCursor("")
+	assert.Nil(t, err)
+	assert.Nil(t, decoded, "first page")
+	_, err = decodeCursor("not a cursor!")
+	assert.Equal(t, err, ErrBadCursor)
+}
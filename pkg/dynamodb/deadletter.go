@@ -0,0 +1,34 @@
+package dynamo
+
+import (
+	"github.com/codemk8/muser/pkg/webhook"
+)
+
+const kindDeadLetter = "deadletter"
+
+// SaveDeadLetter implements webhook.DeadLetterStore
+func (client DynamoClient) SaveDeadLetter(letter *webhook.DeadLetter) error {
+	return client.putRecord(kindDeadLetter, letter.ID, letter)
+}
+
+// GetDeadLetter implements webhook.DeadLetterStore
+func (client DynamoClient) GetDeadLetter(id string) (*webhook.DeadLetter, error) {
+	letter := webhook.DeadLetter{}
+	err := client.getRecord(kindDeadLetter, id, &letter)
+	if err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// ListDeadLetters implements webhook.DeadLetterStore
+func (client DynamoClient) ListDeadLetters() ([]webhook.DeadLetter, error) {
+	letters := []webhook.DeadLetter{}
+	err := client.queryRecords(kindDeadLetter, &letters)
+	return letters, err
+}
+
+// DeleteDeadLetter implements webhook.DeadLetterStore
+func (client DynamoClient) DeleteDeadLetter(id string) error {
+	return client.deleteRecord(kindDeadLetter, id)
+}
@@ -0,0 +1,97 @@
+package dynamo
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+// Deleted users keep their item with StatusDeleted until purged, so the
+// name stays taken and the account can be restored. A purged name can be
+// reserved with a reservation record.
+
+const kindReserved = "reserved"
+
+// RestoreUser brings a soft deleted user back to the status it had before,
+// it fails with ErrNotFound if the user is not deleted
+func (client DynamoClient) RestoreUser(user *schema.User) error {
+	username := user.UserName
+	status := user.RestoreStatus
+	if status == "" {
+		status = schema.StatusActive
+	}
+	update := "SET #s = :status"
+	remove := "REMOVE deleted_at, restore_status, restore_reason"
+	values := map[string]*dynamodb.AttributeValue{
+		":status":  {S: aws.String(status)},
+		":deleted": {S: aws.String(schema.StatusDeleted)},
+	}
+	if user.RestoreReason != "" {
+		update += ", status_reason = :reason"
+		values[":reason"] = &dynamodb.AttributeValue{S: aws.String(user.RestoreReason)}
+	} else {
+		remove += ", status_reason"
+	}
+	update, values = bumpVersion(update+" "+remove, values)
+	_, err := client.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       client.keyAttr(username),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("#s = :deleted"),
+		ExpressionAttributeNames:  map[string]*string{"#s": aws.String("status")},
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(client.table),
+	})
+	if isConditionFailed(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error restoring user %s: %v", username, err)
+	}
+	return err
+}
+
+// PurgeUser hard deletes a user and its memberships, the secret with any
+// code or token goes with the item, and so do its outbox entries and the
+// invitations it sent. The name is reserved first if res is not nil.
+func (client DynamoClient) PurgeUser(user *schema.User, res *schema.Reservation) error {
+	if res != nil {
+		err := client.putRecord(kindReserved, res.Name, res)
+		if err != nil {
+			return err
+		}
+	}
+	err := client.DeleteUser(user)
+	if err != nil {
+		return err
+	}
+	err = client.purgeNotifications(user.UserName)
+	if err != nil {
+		return err
+	}
+	return client.purgeInvitations(user.UserName)
+}
+
+// GetReservation returns the reservation of a user name or ErrNotFound
+func (client DynamoClient) GetReservation(username string) (*schema.Reservation, error) {
+	res := schema.Reservation{}
+	err := client.getRecord(kindReserved, username, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Reserved tells if a user name is reserved
+func (client DynamoClient) Reserved(username string) bool {
+	res, err := client.GetReservation(username)
+	if err != nil {
+		if err != ErrNotFound {
+			// fail closed, the name may be reserved
+			glog.Warningf("Error reading reservation of %s: %v", username, err)
+			return true
+		}
+		return false
+	}
+	return res.Active()
+}
@@ -0,0 +1,263 @@
+package dynamo
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+// Emails are unique in a tenant: the user holding an email also holds its
+// claim record, written in the transaction setting the email. Only verified
+// emails are claimed, so nobody can squat an address by registering it, and
+// a replaced email stays claimed while the change can be reverted. Claims
+// their owner no longer holds, see schema.User.HoldsEmail, are released by
+// the next user wanting the email.
+
+const kindEmail = "email"
+
+// ErrEmailTaken is returned when the email belongs to another user
+var ErrEmailTaken = errors.New("email taken")
+
+// claimCondition lets a user claim a free email or one it already holds
+const claimCondition = "attribute_not_exists(user_name) OR #o = :me"
+
+// claimPut is the transaction item claiming email for username
+func (client DynamoClient) claimPut(email string, username string) (*dynamodb.TransactWriteItem, error) {
+	claim := schema.EmailClaim{Email: email, Owner: username, Created: time.Now().Unix()}
+	item, err := client.recordItem(kindEmail, schema.NormalizeEmail(email), claim)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		Item:                      item,
+		ConditionExpression:       aws.String(claimCondition),
+		ExpressionAttributeNames:  map[string]*string{"#o": aws.String("owner")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":me": {S: aws.String(username)}},
+		TableName:                 aws.String(client.table),
+	}}, nil
+}
+
+// releaseDelete is the transaction item releasing the claim of username on
+// email, it does nothing if the email was never claimed
+func (client DynamoClient) releaseDelete(email string, username string) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+		Key:                       client.recordItemKey(kindEmail, schema.NormalizeEmail(email)),
+		ConditionExpression:       aws.String(claimCondition),
+		ExpressionAttributeNames:  map[string]*string{"#o": aws.String("owner")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":me": {S: aws.String(username)}},
+		TableName:                 aws.String(client.table),
+	}}
+}
+
+// emailCheck is the transaction item failing if email is claimed
+func (client DynamoClient) emailCheck(email string) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{ConditionCheck: &dynamodb.ConditionCheck{
+		Key:                 client.recordItemKey(kindEmail, schema.NormalizeEmail(email)),
+		ConditionExpression: aws.String("attribute_not_exists(user_name)"),
+		TableName:           aws.String(client.table),
+	}}
+}
+
+// getClaim returns the claim of email and whether its owner still holds it
+func (client DynamoClient) getClaim(email string) (*schema.EmailClaim, bool, error) {
+	claim := schema.EmailClaim{}
+	err := client.getRecord(kindEmail, schema.NormalizeEmail(email), &claim)
+	if err != nil {
+		return nil, false, err
+	}
+	owner, err := client.queryUser(claim.Owner, true)
+	if err == errUserNotFound {
+		return &claim, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &claim, owner.HoldsEmail(email, time.Now().Unix()), nil
+}
+
+// releaseStale releases the claim of email unless its owner still holds it
+// or it is username's
+func (client DynamoClient) releaseStale(email string, username string) error {
+	claim, held, err := client.getClaim(email)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil || held || claim.Owner == username {
+		return err
+	}
+	_, err = client.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:                       client.recordItemKey(kindEmail, schema.NormalizeEmail(email)),
+		ConditionExpression:       aws.String("#o = :owner"),
+		ExpressionAttributeNames:  map[string]*string{"#o": aws.String("owner")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":owner": {S: aws.String(claim.Owner)}},
+		TableName:                 aws.String(client.table),
+	})
+	if isConditionFailed(err) {
+		// claimed again meanwhile, the claim of the caller fails
+		return nil
+	}
+	if err == nil {
+		glog.Infof("Released the claim of %s on %s", claim.Owner, email)
+	}
+	return err
+}
+
+// releaseOwned is the transaction item releasing the claim of username on
+// email, nil if username has no claim on it
+func (client DynamoClient) releaseOwned(email string, username string) (*dynamodb.TransactWriteItem, error) {
+	claim := schema.EmailClaim{}
+	err := client.getRecord(kindEmail, schema.NormalizeEmail(email), &claim)
+	if err == ErrNotFound || (err == nil && claim.Owner != username) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client.releaseDelete(email, username), nil
+}
+
+// claimItem is the transaction item claiming the email of a verified user,
+// or checking an unverified one is free, after releasing a stale claim
+func (client DynamoClient) claimItem(user *schema.User) (*dynamodb.TransactWriteItem, error) {
+	err := client.releaseStale(user.Profile.Email, user.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Profile.Verified {
+		return client.emailCheck(user.Profile.Email), nil
+	}
+	return client.claimPut(user.Profile.Email, user.UserName)
+}
+
+// canceledAt returns the index of the first transaction item whose
+// condition failed, -1 if the error is not a canceled transaction. This
+// SDK only reports the reasons in the message, e.g. "Transaction cancelled,
+// please refer cancellation reasons for specific reasons [None,
+// ConditionalCheckFailed]"; without them the first item is blamed.
+func canceledAt(err error) int {
+	if !isTransactionCanceled(err) {
+		return -1
+	}
+	msg := err.(awserr.Error).Message()
+	start := strings.LastIndex(msg, "[")
+	end := strings.LastIndex(msg, "]")
+	if start < 0 || end < start {
+		return 0
+	}
+	for i, reason := range strings.Split(msg[start+1:end], ",") {
+		if strings.TrimSpace(reason) == "ConditionalCheckFailed" {
+			return i
+		}
+	}
+	return 0
+}
+
+// EmailOwner returns the user holding email, or ErrNotFound
+func (client DynamoClient) EmailOwner(email string) (string, error) {
+	claim, held, err := client.getClaim(email)
+	if err != nil {
+		return "", err
+	}
+	if !held {
+		return "", ErrNotFound
+	}
+	return claim.Owner, nil
+}
+
+// RegisterUser creates a new user with the notifications its registration
+// causes, claiming its email if it is verified. It fails with ErrExists if
+// the name is taken and ErrEmailTaken if the email is.
+func (client DynamoClient) RegisterUser(user *schema.User, notes ...verify.VerifyRequest) error {
+	item, err := client.userItem(user)
+	if err != nil {
+		return err
+	}
+	items := []*dynamodb.TransactWriteItem{{Put: &dynamodb.Put{
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(user_name)"),
+		TableName:           aws.String(client.table),
+	}}}
+	if user.Profile.Email != "" {
+		claim, err := client.claimItem(user)
+		if err != nil {
+			return err
+		}
+		items = append(items, claim)
+	}
+	ref, err := client.idPut(user, true)
+	if err != nil {
+		return err
+	}
+	items = append(items, ref)
+	outbox, err := client.outboxPuts(notes)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: append(items, outbox...)})
+	switch at := canceledAt(err); {
+	case at == -1:
+	case at == 1 && user.Profile.Email != "":
+		return ErrEmailTaken
+	default:
+		return ErrExists
+	}
+	if err != nil {
+		glog.Warningf("Error registering user %s: %v", user.UserName, err)
+	}
+	return err
+}
+
+// ChangeEmail writes the user whose email was oldEmail, moving the claim to
+// the new email if verified, with the notifications of the change. It fails
+// with ErrEmailTaken if the new email belongs to another user, and with
+// ErrConflict if the user changed since it was read. An empty oldEmail
+// keeps the claim of the old email for a revert, the claim lapses with the
+// revert token.
+func (client DynamoClient) ChangeEmail(user *schema.User, oldEmail string, notes ...verify.VerifyRequest) error {
+	put, err := client.userPut(user)
+	if err != nil {
+		return err
+	}
+	items := []*dynamodb.TransactWriteItem{{Put: put}}
+	newEmail := user.Profile.Email
+	if newEmail != "" && user.Profile.Verified {
+		claim, err := client.claimItem(user)
+		if err != nil {
+			return err
+		}
+		items = append(items, claim)
+	}
+	if oldEmail != "" && schema.NormalizeEmail(oldEmail) != schema.NormalizeEmail(newEmail) {
+		release, err := client.releaseOwned(oldEmail, user.UserName)
+		if err != nil {
+			return err
+		}
+		if release != nil {
+			items = append(items, release)
+		}
+	}
+	outbox, err := client.outboxPuts(notes)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: append(items, outbox...)})
+	switch at := canceledAt(err); {
+	case at == 0:
+		return ErrConflict
+	case at == 1 && newEmail != "" && user.Profile.Verified:
+		return ErrEmailTaken
+	}
+	if err != nil {
+		glog.Warningf("Error changing email of %s: %v", user.UserName, err)
+		return err
+	}
+	user.Version++
+	return nil
+}
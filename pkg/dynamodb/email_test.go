@@ -0,0 +1,21 @@
+package dynamo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanceledAt(t *testing.T) {
+	canceled := func(msg string) error {
+		return awserr.New(dynamodb.ErrCodeTransactionCanceledException, msg, nil)
+	}
+	assert.Equal(t, canceledAt(nil), -1)
+	assert.Equal(t, canceledAt(errors.New("boom")), -1)
+	assert.Equal(t, canceledAt(canceled("Transaction cancelled, please refer cancellation reasons for specific reasons [None, ConditionalCheckFailed, None]")), 1)
+	assert.Equal(t, canceledAt(canceled("Transaction cancelled, please refer cancellation reasons for specific reasons [ConditionalCheckFailed, None]")), 0)
+	assert.Equal(t, canceledAt(canceled("Transaction cancelled")), 0, "no reasons blames the first item")
+}
@@ -0,0 +1,187 @@
+package dynamo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+const (
+	kindGroup  = "group"
+	kindMember = "member"
+)
+
+// ErrExists is returned when creating something that already exists
+var ErrExists = errors.New("already exists")
+
+func memberID(group string, username string) string {
+	return group + "#" + username
+}
+
+// CreateGroup stores a new group, or returns ErrExists
+func (client DynamoClient) CreateGroup(group *schema.Group) error {
+	item, err := client.recordItem(kindGroup, group.Name, group)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.PutItem(&dynamodb.PutItemInput{
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(user_name)"),
+		TableName:           aws.String(client.table),
+	})
+	if isConditionFailed(err) {
+		return ErrExists
+	}
+	if err != nil {
+		glog.Warningf("Error creating group %s: %v", group.Name, err)
+	}
+	return err
+}
+
+// GetGroup returns a group or ErrNotFound
+func (client DynamoClient) GetGroup(name string) (*schema.Group, error) {
+	group := schema.Group{}
+	err := client.getRecord(kindGroup, name, &group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// UpdateGroup overwrites an existing group, or returns ErrNotFound
+func (client DynamoClient) UpdateGroup(group *schema.Group) error {
+	item, err := client.recordItem(kindGroup, group.Name, group)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.PutItem(&dynamodb.PutItemInput{
+		Item:                item,
+		ConditionExpression: aws.String("attribute_exists(user_name)"),
+		TableName:           aws.String(client.table),
+	})
+	if isConditionFailed(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DeleteGroup removes a group and all its memberships
+func (client DynamoClient) DeleteGroup(name string) error {
+	cursor := ""
+	for {
+		members, next, err := client.ListMembers(name, cursor, 100)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			err = client.RemoveMember(name, m.Member)
+			if err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return client.deleteRecord(kindGroup, name)
+}
+
+// ListGroups returns a page of groups
+func (client DynamoClient) ListGroups(cursor string, limit int) ([]schema.Group, string, error) {
+	items, next, err := client.queryPage(kindGroup, "", nil, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	groups := []schema.Group{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &groups)
+	return groups, next, err
+}
+
+// AddMember adds an existing user to an existing group
+func (client DynamoClient) AddMember(group string, username string) error {
+	_, err := client.GetGroup(group)
+	if err != nil {
+		return err
+	}
+	membership := schema.Membership{Group: group, Member: username, Added: time.Now().Unix()}
+	item, err := client.recordItem(kindMember, memberID(group, username), membership)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: client.userGroupsUpdate(username, "ADD groups :g", group)},
+			{Put: &dynamodb.Put{Item: item, TableName: aws.String(client.table)}},
+		},
+	})
+	if isTransactionCanceled(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error adding %s to group %s: %v", username, group, err)
+	}
+	return err
+}
+
+// RemoveMember removes a user from a group
+func (client DynamoClient) RemoveMember(group string, username string) error {
+	_, err := client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: client.userGroupsUpdate(username, "DELETE groups :g", group)},
+			{Delete: &dynamodb.Delete{
+				Key:       client.recordItemKey(kindMember, memberID(group, username)),
+				TableName: aws.String(client.table),
+			}},
+		},
+	})
+	if isTransactionCanceled(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error removing %s from group %s: %v", username, group, err)
+	}
+	return err
+}
+
+func (client DynamoClient) userGroupsUpdate(username string, update string, group string) *dynamodb.Update {
+	update, values := bumpVersion(update, map[string]*dynamodb.AttributeValue{
+		":g": {SS: aws.StringSlice([]string{group})},
+	})
+	return &dynamodb.Update{
+		Key:                       client.keyAttr(username),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(user_name)"),
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(client.table),
+	}
+}
+
+// ListMembers returns a page of the memberships of a group
+func (client DynamoClient) ListMembers(group string, cursor string, limit int) ([]schema.Membership, string, error) {
+	cond := expression.Name("group").Equal(expression.Value(group))
+	items, next, err := client.queryPage(kindMember, memberID(group, ""), &cond, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	members := []schema.Membership{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &members)
+	return members, next, err
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func isTransactionCanceled(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeTransactionCanceledException
+}
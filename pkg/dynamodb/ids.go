@@ -0,0 +1,91 @@
+package dynamo
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+// A user ID is looked up by its reference record, pointing to the current
+// name of the user. It is written with the user and moved by renames.
+
+const kindID = "id"
+
+// idPut is the transaction item pointing the ID of user to its name, a new
+// reference fails if the ID is taken
+func (client DynamoClient) idPut(user *schema.User, create bool) (*dynamodb.TransactWriteItem, error) {
+	ref := schema.UserRef{ID: user.ID, Owner: user.UserName, Created: user.Created}
+	item, err := client.recordItem(kindID, user.ID, ref)
+	if err != nil {
+		return nil, err
+	}
+	put := &dynamodb.Put{Item: item, TableName: aws.String(client.table)}
+	if create {
+		put.ConditionExpression = aws.String("attribute_not_exists(user_name)")
+	}
+	return &dynamodb.TransactWriteItem{Put: put}, nil
+}
+
+// GetUserByID returns the user with the ID, or ErrNotFound
+func (client DynamoClient) GetUserByID(id string, getSecret bool) (*schema.User, error) {
+	ref := schema.UserRef{}
+	err := client.getRecord(kindID, id, &ref)
+	if err != nil {
+		return nil, err
+	}
+	user, err := client.queryUser(ref.Owner, getSecret)
+	if err != nil || user.ID != id {
+		// the user was deleted meanwhile
+		return nil, ErrNotFound
+	}
+	return user, nil
+}
+
+// EnsureUserID gives an ID to a user created before IDs, concurrent calls
+// agree on one. The user as read follows the write.
+func (client DynamoClient) EnsureUserID(user *schema.User) error {
+	if user.ID != "" {
+		return nil
+	}
+	assigned := *user
+	assigned.ID = schema.NewUserID()
+	if assigned.Created == 0 {
+		assigned.Created = time.Now().Unix()
+	}
+	ref, err := client.idPut(&assigned, true)
+	if err != nil {
+		return err
+	}
+	update, values := bumpVersion("SET id = :id", map[string]*dynamodb.AttributeValue{":id": {S: aws.String(assigned.ID)}})
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: &dynamodb.Update{
+				Key:                       client.keyAttr(user.UserName),
+				UpdateExpression:          aws.String(update),
+				ConditionExpression:       aws.String("attribute_exists(user_name) AND attribute_not_exists(id)"),
+				ExpressionAttributeValues: values,
+				TableName:                 aws.String(client.table),
+			}},
+			ref,
+		},
+	})
+	if isTransactionCanceled(err) {
+		// assigned by another request, or the user is gone
+		current, err := client.queryUser(user.UserName, false)
+		if err != nil {
+			return err
+		}
+		user.ID = current.ID
+		return nil
+	}
+	if err != nil {
+		glog.Warningf("Error assigning an id to %s: %v", user.UserName, err)
+		return err
+	}
+	user.ID = assigned.ID
+	user.Version++
+	return nil
+}
@@ -0,0 +1,131 @@
+package dynamo
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+const kindInvite = "invite"
+
+// ErrNoQuota is returned when the inviter has no invitation left
+var ErrNoQuota = errors.New("no invitation quota left")
+
+// CreateInvitation stores the invitation with the notification sending it,
+// taking one from the invite quota of the inviter if useQuota is set
+func (client DynamoClient) CreateInvitation(inv *schema.Invitation, useQuota bool, note verify.VerifyRequest) error {
+	item, err := client.recordItem(kindInvite, inv.TokenHash, inv)
+	if err != nil {
+		return err
+	}
+	items := []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{Item: item, TableName: aws.String(client.table)}},
+	}
+	if useQuota {
+		update, values := bumpVersion("SET invite_quota = invite_quota - :one", nil)
+		items = append(items, &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+			Key:                       client.keyAttr(inv.InvitedBy),
+			UpdateExpression:          aws.String(update),
+			ConditionExpression:       aws.String("invite_quota >= :one"),
+			ExpressionAttributeValues: values,
+			TableName:                 aws.String(client.table),
+		}})
+	}
+	outbox, err := client.outboxPuts([]verify.VerifyRequest{note})
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: append(items, outbox...)})
+	if useQuota && canceledAt(err) == 1 {
+		return ErrNoQuota
+	}
+	if err != nil {
+		// conflicts with concurrent writes are not a lack of quota
+		glog.Warningf("Error creating invitation by %s: %v", inv.InvitedBy, err)
+	}
+	return err
+}
+
+// GetInvitation returns the invitation of a token hash or ErrNotFound
+func (client DynamoClient) GetInvitation(tokenHash string) (*schema.Invitation, error) {
+	inv := schema.Invitation{}
+	err := client.getRecord(kindInvite, tokenHash, &inv)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// RegisterInvited creates a new user, claiming the invited email, and marks
+// the invitation used in one transaction. It fails with ErrExists if the
+// user name is taken or the invitation was used meanwhile, and with
+// ErrEmailTaken if the email belongs to another user.
+func (client DynamoClient) RegisterInvited(user *schema.User, tokenHash string) error {
+	item, err := client.userItem(user)
+	if err != nil {
+		return err
+	}
+	claim, err := client.claimItem(user)
+	if err != nil {
+		return err
+	}
+	ref, err := client.idPut(user, true)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Put: &dynamodb.Put{
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(user_name)"),
+				TableName:           aws.String(client.table),
+			}},
+			claim,
+			ref,
+			{Update: &dynamodb.Update{
+				Key:                 client.recordItemKey(kindInvite, tokenHash),
+				UpdateExpression:    aws.String("SET used = :used, used_by = :user"),
+				ConditionExpression: aws.String("attribute_exists(user_name) AND attribute_not_exists(used)"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":used": {BOOL: aws.Bool(true)},
+					":user": {S: aws.String(user.UserName)},
+				},
+				TableName: aws.String(client.table),
+			}},
+		},
+	})
+	switch canceledAt(err) {
+	case -1:
+	case 1:
+		return ErrEmailTaken
+	default:
+		return ErrExists
+	}
+	if err != nil {
+		glog.Warningf("Error registering invited user %s: %v", user.UserName, err)
+	}
+	return err
+}
+
+// purgeInvitations deletes the invitations a user sent or registered with
+func (client DynamoClient) purgeInvitations(username string) error {
+	cond := expression.Name("invited_by").Equal(expression.Value(username)).
+		Or(expression.Name("used_by").Equal(expression.Value(username)))
+	invitations := []schema.Invitation{}
+	err := client.queryRecordsWhere(kindInvite, "", &cond, &invitations)
+	if err != nil {
+		return err
+	}
+	for _, inv := range invitations {
+		err = client.deleteRecord(kindInvite, inv.TokenHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package dynamo
+
+import (
+	"time"
+
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+// Users registered before names were canonical may be stored under keys like
+// "Alice". MigrateUserNames moves them to their canonical key once per
+// tenant, so a new "alice" can never shadow them.
+
+// namesMigrated records that the keys of a tenant are canonical
+type namesMigrated struct {
+	Moved    int   `json:"moved"`
+	Kept     int   `json:"kept"`
+	Migrated int64 `json:"migrated"`
+}
+
+// MigrateUserNames moves every user stored under a non-canonical key to its
+// canonical key, like a rename without history or redirect. A user that can't
+// move, because the canonical key or its email claim is taken, keeps its key and the canonical name is reserved for it, so it
+// can't be registered by someone else. It does nothing once it succeeded.
+func (client DynamoClient) MigrateUserNames() error {
+	done := namesMigrated{}
+	err := client.getRecord(kindMeta, "canonical_names", &done)
+	if err == nil {
+		return nil
+	}
+	if err != ErrNotFound {
+		return err
+	}
+	cursor := ""
+	for {
+		users, next, err := client.ListUsers(UserFilter{}, cursor, 100)
+		if err != nil {
+			return err
+		}
+		for i := range users {
+			if !needsMigration(users[i].UserName) {
+				continue
+			}
+			moved, err := client.migrateUserName(users[i].UserName)
+			if err != nil {
+				return err
+			}
+			if moved {
+				done.Moved++
+			} else {
+				done.Kept++
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	done.Migrated = time.Now().Unix()
+	glog.Infof("Migrated user names of tenant %q, %d moved, %d kept", client.tenant, done.Moved, done.Kept)
+	return client.putRecord(kindMeta, "canonical_names", done)
+}
+
+// needsMigration tells if name is not canonical, names that can't be made
+// canonical stay as they are since no new user can collide with them
+func needsMigration(name string) bool {
+	canonical, err := schema.CanonicalUserName(name)
+	return err == nil && canonical != name
+}
+
+// migrateUserName moves the user stored under the non-canonical name to its
+// canonical key, it tells if the user moved
+func (client DynamoClient) migrateUserName(name string) (bool, error) {
+	canonical, _ := schema.CanonicalUserName(name)
+	user, err := client.queryUser(name, true)
+	if err != nil {
+		return false, err
+	}
+	moved, err := client.moveToCanonical(user, canonical)
+	if moved || (err != ErrExists && err != ErrEmailTaken) {
+		return moved, err
+	}
+	glog.Warningf("Can't move user %s to %s: %v", name, canonical, err)
+	if _, err := client.queryUser(canonical, false); err == nil {
+		// the canonical name is taken, registration checks it
+		return false, nil
+	}
+	res := schema.Reservation{Name: canonical, Reason: "legacy name " + name, Created: time.Now().Unix()}
+	return false, client.putRecord(kindReserved, canonical, res)
+}
+
+// moveToCanonical moves user to the canonical key
+func (client DynamoClient) moveToCanonical(user *schema.User, canonical string) (bool, error) {
+	renamed := *user
+	renamed.UserName = canonical
+	items, emailAt, err := client.moveItems(user, &renamed)
+	if err != nil {
+		return false, err
+	}
+	err = client.moveUser(items, emailAt)
+	if err != nil {
+		return false, err
+	}
+	client.moveMemberships(user, canonical)
+	glog.Infof("Moved user %s to %s", user.UserName, canonical)
+	return true, nil
+}
@@ -0,0 +1,14 @@
+package dynamo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNeedsMigration(t *testing.T) {
+	assert.Equal(t, needsMigration("alice"), false, "canonical")
+	assert.Equal(t, needsMigration("Alice"), true, "upper case")
+	assert.Equal(t, needsMigration("ＡＬＩＣＥ"), true, "full width")
+	assert.Equal(t, needsMigration("Аlice"), false, "mixed scripts can't be canonical")
+}
@@ -0,0 +1,215 @@
+package dynamo
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+const kindOutbox = "outbox"
+
+// SaveUser writes the user together with the notifications its change
+// causes in one transaction, so either both are stored or neither is. It
+// fails with ErrConflict if the user changed since it was read.
+func (client DynamoClient) SaveUser(user *schema.User, notes ...verify.VerifyRequest) error {
+	if len(notes) == 0 {
+		return client.AddNewUser(user)
+	}
+	put, err := client.userPut(user)
+	if err != nil {
+		return err
+	}
+	items := []*dynamodb.TransactWriteItem{{Put: put}}
+	outbox, err := client.outboxPuts(notes)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: append(items, outbox...)})
+	if canceledAt(err) == 0 {
+		return ErrConflict
+	}
+	if err != nil {
+		glog.Warningf("Error writing user %s with notifications: %v", user.UserName, err)
+		return err
+	}
+	user.Version++
+	return nil
+}
+
+// userPut is the transaction item writing the user as read, see
+// versionCondition
+func (client DynamoClient) userPut(user *schema.User) (*dynamodb.Put, error) {
+	item, err := client.userItem(user)
+	if err != nil {
+		return nil, err
+	}
+	cond, values := versionCondition(user)
+	return &dynamodb.Put{
+		Item:                      item,
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(client.table),
+	}, nil
+}
+
+// SetSealer sets the sealer of the outbox secrets, the clients of tenants
+// created afterwards share it
+func (client *DynamoClient) SetSealer(sealer *verify.Sealer) {
+	client.sealer = sealer
+}
+
+// outboxPuts are the transaction items adding notifications to the outbox,
+// with their secrets sealed
+func (client DynamoClient) outboxPuts(notes []verify.VerifyRequest) ([]*dynamodb.TransactWriteItem, error) {
+	if client.sealer == nil {
+		return nil, errors.New("no sealer for the outbox secrets")
+	}
+	items := []*dynamodb.TransactWriteItem{}
+	for _, note := range notes {
+		entry := verify.NewOutboxEntry(note)
+		entry.Tenant = client.tenant
+		err := client.sealer.Seal(&entry)
+		if err != nil {
+			return nil, err
+		}
+		item, err := client.recordItem(kindOutbox, entry.ID, entry)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{Item: item, TableName: aws.String(client.table)},
+		})
+	}
+	return items, nil
+}
+
+// outboxIndex is the global secondary index of the table keyed by kind and
+// next_attempt. Dead entries have no next_attempt, so it only holds the
+// pending ones.
+const outboxIndex = "outbox-index"
+
+// outboxBatch bounds the entries returned by one PendingNotifications, the
+// rest are returned by the next ones
+const outboxBatch = 100
+
+// PendingNotifications implements verify.OutboxStore
+func (client DynamoClient) PendingNotifications(now int64) ([]verify.OutboxEntry, error) {
+	key := expression.Key("kind").Equal(expression.Value(kindOutbox)).
+		And(expression.Key("next_attempt").LessThanEqual(expression.Value(now)))
+	// entries which died before they left the index
+	filter := expression.Name("dead").AttributeNotExists()
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.svc.Query(&dynamodb.QueryInput{
+		IndexName:                 aws.String(outboxIndex),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		Limit:                     aws.Int64(outboxBatch),
+		TableName:                 aws.String(client.table),
+	})
+	if err != nil {
+		glog.Warningf("Error querying the outbox: %v", err)
+		return nil, err
+	}
+	entries := []verify.OutboxEntry{}
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &entries)
+	return entries, err
+}
+
+// ClaimNotification implements verify.OutboxStore, the attempt counter is
+// used as an optimistic lock between workers
+func (client DynamoClient) ClaimNotification(entry *verify.OutboxEntry, until int64) (bool, error) {
+	_, err := client.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 client.recordItemKey(kindOutbox, entry.ID),
+		UpdateExpression:    aws.String("SET attempts = :next, next_attempt = :until"),
+		ConditionExpression: aws.String("attempts = :attempts"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":attempts": {N: aws.String(strconv.Itoa(entry.Attempts))},
+			":next":     {N: aws.String(strconv.Itoa(entry.Attempts + 1))},
+			":until":    {N: aws.String(strconv.FormatInt(until, 10))},
+		},
+		TableName: aws.String(client.table),
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return false, nil
+		}
+		glog.Warningf("Error claiming notification %s: %v", entry.ID, err)
+		return false, err
+	}
+	entry.Attempts++
+	entry.NextAttempt = until
+	return true, nil
+}
+
+// SaveNotification implements verify.OutboxStore
+func (client DynamoClient) SaveNotification(entry *verify.OutboxEntry) error {
+	return client.putRecord(kindOutbox, entry.ID, entry)
+}
+
+// RedactDeadNotifications drops the secrets kept by dead entries written
+// before secrets were sealed and redacted, and takes the dead entries
+// written before they dropped next_attempt out of outboxIndex
+func (client DynamoClient) RedactDeadNotifications() error {
+	cond := expression.Name("dead").AttributeExists()
+	entries := []verify.OutboxEntry{}
+	err := client.queryRecordsWhere(kindOutbox, "", &cond, &entries)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Sealed == "" && entry.Request.VerifyCode == "" &&
+			entry.Request.RevertLink == "" && entry.Request.Link == "" && entry.NextAttempt == 0 {
+			continue
+		}
+		entry.Redact()
+		entry.NextAttempt = 0
+		err = client.SaveNotification(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeNotifications deletes the outbox entries, dead ones included, sent
+// to or on behalf of a user of the client's tenant
+func (client DynamoClient) purgeNotifications(username string) error {
+	tenant := expression.Name("tenant").AttributeNotExists()
+	if client.tenant != "" {
+		tenant = expression.Name("tenant").Equal(expression.Value(client.tenant))
+	}
+	cond := tenant.And(expression.Or(
+		expression.Name("request.user_name").Equal(expression.Value(username)),
+		expression.Name("request.type").Equal(expression.Value(verify.TypeInvitation)).
+			And(expression.Name("request.detail").Equal(expression.Value(username)))))
+	entries := []verify.OutboxEntry{}
+	err := client.queryRecordsWhere(kindOutbox, "", &cond, &entries)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = client.DeleteNotification(entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteNotification implements verify.OutboxStore
+func (client DynamoClient) DeleteNotification(id string) error {
+	return client.deleteRecord(kindOutbox, id)
+}
@@ -0,0 +1,143 @@
+package dynamo
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/golang/glog"
+)
+
+// Records are the non user items kept in the user table. Their key is
+// "#<kind>#<id>", qualified by the tenant unless the kind is global, which
+// can never be a user name, and a "kind" attribute tells them apart and
+// puts them in kindIndex.
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("not found")
+
+func (client DynamoClient) recordKey(kind string, id string) string {
+	key := "#" + kind + "#" + id
+	if globalKinds[kind] {
+		return key
+	}
+	return client.key(key)
+}
+
+// putRecord marshals v as the record kind/id, overwriting any previous one
+func (client DynamoClient) putRecord(kind string, id string, v interface{}) error {
+	item, err := client.recordItem(kind, id, v)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.PutItem(&dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(client.table),
+	})
+	if err != nil {
+		glog.Warningf("Error putting %s record: %v", kind, err)
+	}
+	return err
+}
+
+func (client DynamoClient) recordItem(kind string, id string, v interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	item, err := dynamodbattribute.MarshalMap(v)
+	if err != nil {
+		glog.Warningf("Error marshal %s record: %v", kind, err)
+		return nil, err
+	}
+	item["user_name"] = &dynamodb.AttributeValue{S: aws.String(client.recordKey(kind, id))}
+	item["kind"] = &dynamodb.AttributeValue{S: aws.String(kind)}
+	if !globalKinds[kind] {
+		client.tagTenant(item)
+	}
+	return item, nil
+}
+
+// getRecord unmarshals the record kind/id into v, or returns ErrNotFound
+func (client DynamoClient) getRecord(kind string, id string, v interface{}) error {
+	result, err := client.svc.GetItem(&dynamodb.GetItemInput{
+		Key:            client.recordItemKey(kind, id),
+		TableName:      aws.String(client.table),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		glog.Warningf("Error getting %s record: %v", kind, err)
+		return err
+	}
+	if len(result.Item) == 0 {
+		return ErrNotFound
+	}
+	return dynamodbattribute.UnmarshalMap(result.Item, v)
+}
+
+func (client DynamoClient) deleteRecord(kind string, id string) error {
+	_, err := client.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:       client.recordItemKey(kind, id),
+		TableName: aws.String(client.table),
+	})
+	if err != nil {
+		glog.Warningf("Error deleting %s record: %v", kind, err)
+	}
+	return err
+}
+
+// kindIndex is the global secondary index of the table keyed by kind and
+// user_name. Users have no kind, so it only holds the records.
+const kindIndex = "kind-index"
+
+// queryRecords unmarshals all records of a kind of the client's tenant into
+// out, a pointer to a slice
+func (client DynamoClient) queryRecords(kind string, out interface{}) error {
+	return client.queryRecordsWhere(kind, "", nil, out)
+}
+
+// queryRecordsWhere is queryRecords limited to the records whose id starts
+// with prefix and matching cond
+func (client DynamoClient) queryRecordsWhere(kind string, prefix string, cond *expression.ConditionBuilder, out interface{}) error {
+	input, err := client.recordsQuery(kind, prefix, cond)
+	if err != nil {
+		return err
+	}
+	items := []map[string]*dynamodb.AttributeValue{}
+	err = client.svc.QueryPages(input, func(page *dynamodb.QueryOutput, last bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		glog.Warningf("Error querying %s records: %v", kind, err)
+		return err
+	}
+	return dynamodbattribute.UnmarshalListOfMaps(items, out)
+}
+
+// recordsQuery reads the records of a kind whose id starts with prefix
+// through kindIndex, the key prefix scopes it to the client's tenant
+func (client DynamoClient) recordsQuery(kind string, prefix string, cond *expression.ConditionBuilder) (*dynamodb.QueryInput, error) {
+	key := expression.Key("kind").Equal(expression.Value(kind)).
+		And(expression.Key("user_name").BeginsWith(client.recordKey(kind, prefix)))
+	builder := expression.NewBuilder().WithKeyCondition(key)
+	if cond != nil {
+		builder = builder.WithFilter(*cond)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.QueryInput{
+		IndexName:                 aws.String(kindIndex),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		TableName:                 aws.String(client.table),
+	}, nil
+}
+
+func (client DynamoClient) recordItemKey(kind string, id string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"user_name": {S: aws.String(client.recordKey(kind, id))},
+	}
+}
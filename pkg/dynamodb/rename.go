@@ -0,0 +1,176 @@
+package dynamo
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+// RenameUser moves a user, read with its secret, to newName in one
+// transaction: the user item, its email claim and ID reference move, the
+// rename is added to the name history and the old name is reserved by res,
+// which redirects to newName. The notes are sent with it. The memberships
+// follow once the user moved, see moveMemberships. It fails with ErrExists
+// if newName is taken and ErrConflict if the user changed since it was read.
+func (client DynamoClient) RenameUser(user *schema.User, newName string, res *schema.Reservation, notes ...verify.VerifyRequest) error {
+	oldName := user.UserName
+	renamed := *user
+	renamed.UserName = newName
+	renamed.NameHistory = append(append([]schema.NameChange{}, user.NameHistory...),
+		schema.NameChange{From: oldName, To: newName, Changed: time.Now().Unix()})
+	items, emailAt, err := client.moveItems(user, &renamed)
+	if err != nil {
+		return err
+	}
+	res.RedirectTo = newName
+	resItem, err := client.recordItem(kindReserved, oldName, res)
+	if err != nil {
+		return err
+	}
+	items = append(items,
+		&dynamodb.TransactWriteItem{Put: &dynamodb.Put{Item: resItem, TableName: aws.String(client.table)}},
+		// renaming back lifts the reservation of the new name
+		&dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			Key:       client.recordItemKey(kindReserved, newName),
+			TableName: aws.String(client.table),
+		}})
+	if len(notes) > 0 {
+		outbox, err := client.outboxPuts(notes)
+		if err != nil {
+			return err
+		}
+		items = append(items, outbox...)
+	}
+	err = client.moveUser(items, emailAt)
+	if err != nil {
+		if err != ErrExists && err != ErrEmailTaken && err != ErrNotFound && err != ErrConflict {
+			glog.Warningf("Error renaming %s to %s: %v", oldName, newName, err)
+		}
+		return err
+	}
+	client.moveMemberships(user, newName)
+	return nil
+}
+
+// moveItems are the transaction items moving user to the key of renamed: the
+// new item comes first, then the delete of the old one. emailAt is the index
+// of the email claim or -1.
+func (client DynamoClient) moveItems(user, renamed *schema.User) ([]*dynamodb.TransactWriteItem, int, error) {
+	oldName, newName := user.UserName, renamed.UserName
+	item, err := client.userItem(renamed)
+	if err != nil {
+		return nil, -1, err
+	}
+	// the old item goes only as read, the new one is written from it
+	cond, values := versionCondition(user)
+	items := []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(user_name)"),
+			TableName:           aws.String(client.table),
+		}},
+		{Delete: &dynamodb.Delete{
+			Key:                       client.keyAttr(oldName),
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeValues: values,
+			TableName:                 aws.String(client.table),
+		}},
+	}
+	emailAt := -1
+	if user.Profile.Email != "" && !user.Profile.Verified {
+		// unverified emails are not claimed, drop a claim from before
+		release, err := client.releaseOwned(user.Profile.Email, oldName)
+		if err != nil {
+			return nil, -1, err
+		}
+		if release != nil {
+			items = append(items, release)
+		}
+	} else if user.Profile.Email != "" {
+		claim := schema.EmailClaim{Email: user.Profile.Email, Owner: newName, Created: time.Now().Unix()}
+		claimItem, err := client.recordItem(kindEmail, schema.NormalizeEmail(user.Profile.Email), claim)
+		if err != nil {
+			return nil, -1, err
+		}
+		emailAt = len(items)
+		items = append(items, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			Item:                      claimItem,
+			ConditionExpression:       aws.String(claimCondition),
+			ExpressionAttributeNames:  map[string]*string{"#o": aws.String("owner")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":me": {S: aws.String(oldName)}},
+			TableName:                 aws.String(client.table),
+		}})
+	}
+	if user.ID != "" {
+		ref, err := client.idPut(renamed, false)
+		if err != nil {
+			return nil, -1, err
+		}
+		items = append(items, ref)
+	}
+	return items, emailAt, nil
+}
+
+// moveMemberships moves the memberships of user to newName after the user
+// item moved, one group at a time so a user can be in any number of groups.
+// The groups of the user item don't change with its name. A membership that
+// fails to move is left under the old name and logged.
+func (client DynamoClient) moveMemberships(user *schema.User, newName string) {
+	oldName := user.UserName
+	for _, group := range user.Groups {
+		err := client.moveMembership(group, oldName, newName)
+		if err != nil {
+			glog.Warningf("Failed to move membership of %s in %s to %s: %v", oldName, group, newName, err)
+		}
+	}
+}
+
+func (client DynamoClient) moveMembership(group string, oldName string, newName string) error {
+	membership := schema.Membership{}
+	err := client.getRecord(kindMember, memberID(group, oldName), &membership)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	membership.Group = group
+	membership.Member = newName
+	if membership.Added == 0 {
+		membership.Added = time.Now().Unix()
+	}
+	item, err := client.recordItem(kindMember, memberID(group, newName), membership)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Delete: &dynamodb.Delete{
+				Key:       client.recordItemKey(kindMember, memberID(group, oldName)),
+				TableName: aws.String(client.table),
+			}},
+			{Put: &dynamodb.Put{Item: item, TableName: aws.String(client.table)}},
+		},
+	})
+	return err
+}
+
+// moveUser runs the items of moveItems, it fails with ErrExists if the new
+// key is taken, ErrConflict if the user changed since it was read,
+// ErrEmailTaken if the claim moved and ErrNotFound if anything else did
+func (client DynamoClient) moveUser(items []*dynamodb.TransactWriteItem, emailAt int) error {
+	_, err := client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	switch at := canceledAt(err); {
+	case at == -1:
+	case at == 0:
+		return ErrExists
+	case at == 1:
+		return ErrConflict
+	case at == emailAt:
+		return ErrEmailTaken
+	default:
+		return ErrNotFound
+	}
+	return err
+}
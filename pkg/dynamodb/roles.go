@@ -0,0 +1,82 @@
+package dynamo
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+const kindMeta = "meta"
+
+// bootstrap records that the first admin was created
+type bootstrap struct {
+	Admin   string `json:"admin"`
+	Created int64  `json:"created"`
+}
+
+// GrantRole adds a role to an existing user
+func (client DynamoClient) GrantRole(username string, role string) error {
+	return client.updateRoles(username, "ADD roles :r", role)
+}
+
+// RevokeRole removes a role from an existing user
+func (client DynamoClient) RevokeRole(username string, role string) error {
+	return client.updateRoles(username, "DELETE roles :r", role)
+}
+
+func (client DynamoClient) updateRoles(username string, update string, role string) error {
+	update, values := bumpVersion(update, map[string]*dynamodb.AttributeValue{
+		":r": {SS: aws.StringSlice([]string{role})},
+	})
+	_, err := client.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       client.keyAttr(username),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(user_name)"),
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(client.table),
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return ErrNotFound
+		}
+		glog.Warningf("Error updating roles of %s: %v", username, err)
+	}
+	return err
+}
+
+// Bootstrap creates the first admin once, the returned bool tells if admin
+// was created with its password. A user with the admin name fails with
+// ErrExists, unless promote: it is then granted the admin role and keeps
+// its own password.
+func (client DynamoClient) Bootstrap(admin *schema.User, promote bool) (bool, error) {
+	done := bootstrap{}
+	err := client.getRecord(kindMeta, "bootstrap", &done)
+	if err == nil {
+		return false, nil
+	}
+	if err != ErrNotFound {
+		return false, err
+	}
+	created := false
+	if client.UserExist(admin.UserName) {
+		if !promote {
+			return false, ErrExists
+		}
+		err = client.GrantRole(admin.UserName, schema.RoleAdmin)
+		if err == nil {
+			glog.Warningf("Promoted the existing user %s to admin", admin.UserName)
+		}
+	} else {
+		admin.Roles = []string{schema.RoleAdmin}
+		err = client.RegisterUser(admin)
+		created = err == nil
+	}
+	if err != nil {
+		return false, err
+	}
+	done = bootstrap{Admin: admin.UserName, Created: time.Now().Unix()}
+	return created, client.putRecord(kindMeta, "bootstrap", &done)
+}
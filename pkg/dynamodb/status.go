@@ -0,0 +1,186 @@
+package dynamo
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/codemk8/muser/pkg/verify"
+	"github.com/golang/glog"
+)
+
+// statusUpdate sets the status of an existing user, until is the end of a
+// suspension or lock, 0 is none. Locking resets the failed logins so the
+// count starts over once the lock is over.
+func (client DynamoClient) statusUpdate(username string, status string, reason string, until int64) *dynamodb.Update {
+	set := []string{"#s = :s", "status_changed = :c"}
+	remove := []string{}
+	values := map[string]*dynamodb.AttributeValue{
+		":s": {S: aws.String(status)},
+		":c": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+	}
+	if reason != "" {
+		set = append(set, "status_reason = :r")
+		values[":r"] = &dynamodb.AttributeValue{S: aws.String(reason)}
+	} else {
+		remove = append(remove, "status_reason")
+	}
+	if until > 0 {
+		set = append(set, "status_until = :u")
+		values[":u"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(until, 10))}
+	} else {
+		remove = append(remove, "status_until")
+	}
+	if status != schema.StatusDeleted {
+		remove = append(remove, "deleted_at", "restore_status", "restore_reason")
+	}
+	if status == schema.StatusLocked || status == schema.StatusActive {
+		remove = append(remove, "secret.failed_logins")
+	}
+	update := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " REMOVE " + strings.Join(remove, ", ")
+	}
+	update, values = bumpVersion(update, values)
+	return &dynamodb.Update{
+		Key:                       client.keyAttr(username),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(user_name)"),
+		ExpressionAttributeNames:  map[string]*string{"#s": aws.String("status")},
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(client.table),
+	}
+}
+
+// SetUserStatus changes the status of an account, with the notifications
+// telling the user in the same transaction
+func (client DynamoClient) SetUserStatus(username string, status string, reason string, until int64,
+	notes ...verify.VerifyRequest) error {
+	items := []*dynamodb.TransactWriteItem{{Update: client.statusUpdate(username, status, reason, until)}}
+	outbox, err := client.outboxPuts(notes)
+	if err != nil {
+		return err
+	}
+	items = append(items, outbox...)
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if isTransactionCanceled(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error setting status of %s: %v", username, err)
+	}
+	return err
+}
+
+// LockUser locks an active account until a time, with the notifications
+// telling the user. It fails with ErrNotFound if the account is not
+// active, or no longer: a lock must not replace another status.
+func (client DynamoClient) LockUser(username string, until int64, notes ...verify.VerifyRequest) error {
+	update := client.statusUpdate(username, schema.StatusLocked, "too many failed logins", until)
+	// active as User.CurrentStatus tells, a suspension or lock is over
+	// once status_until is past
+	update.ConditionExpression = aws.String("attribute_exists(user_name) AND (attribute_not_exists(#s) OR #s = :active OR " +
+		"((#s = :suspended OR #s = :locked) AND attribute_exists(status_until) AND status_until <= :now))")
+	update.ExpressionAttributeValues[":active"] = &dynamodb.AttributeValue{S: aws.String(schema.StatusActive)}
+	update.ExpressionAttributeValues[":suspended"] = &dynamodb.AttributeValue{S: aws.String(schema.StatusSuspended)}
+	update.ExpressionAttributeValues[":locked"] = &dynamodb.AttributeValue{S: aws.String(schema.StatusLocked)}
+	update.ExpressionAttributeValues[":now"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))}
+	items := []*dynamodb.TransactWriteItem{{Update: update}}
+	outbox, err := client.outboxPuts(notes)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: append(items, outbox...)})
+	if isTransactionCanceled(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error locking %s: %v", username, err)
+	}
+	return err
+}
+
+// FailedLogin counts a wrong password and returns the number of
+// consecutive ones
+func (client DynamoClient) FailedLogin(username string) (int, error) {
+	update, values := bumpVersion("ADD secret.failed_logins :one", nil)
+	result, err := client.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       client.keyAttr(username),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(user_name)"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedNew),
+		TableName:                 aws.String(client.table),
+	})
+	if isConditionFailed(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		glog.Warningf("Error counting failed login of %s: %v", username, err)
+		return 0, err
+	}
+	count := 0
+	if secret := result.Attributes["secret"]; secret != nil && secret.M["failed_logins"] != nil {
+		count, err = strconv.Atoi(aws.StringValue(secret.M["failed_logins"].N))
+	}
+	return count, err
+}
+
+// ClearFailedLogins resets the count of wrong passwords of the user after a
+// login, the user as read follows the write
+func (client DynamoClient) ClearFailedLogins(user *schema.User) error {
+	err := client.updateUser(user.UserName, "REMOVE secret.failed_logins", nil)
+	if err == nil {
+		user.Secret.FailedLogins = 0
+		user.Version++
+	}
+	return err
+}
+
+// ErrTooManyAttempts is returned when no verification code can be tried
+// until the window of the attempts is over, see schema.MaxVerifyAttempts
+var ErrTooManyAttempts = errors.New("too many verification attempts")
+
+// CountVerifyAttempt takes one of the attempts at the verification code of
+// the user, before the code is checked so that parallel guesses can't try
+// more. It fails with ErrTooManyAttempts if none is left. The user as read
+// follows the write.
+func (client DynamoClient) CountVerifyAttempt(user *schema.User) error {
+	update, values := bumpVersion("SET secret.attempts_since = if_not_exists(secret.attempts_since, :now) ADD secret.verify_attempts :one",
+		map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+			":max": {N: aws.String(strconv.Itoa(schema.MaxVerifyAttempts))},
+		})
+	result, err := client.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:              client.keyAttr(user.UserName),
+		UpdateExpression: aws.String(update),
+		ConditionExpression: aws.String("attribute_exists(user_name) AND " +
+			"(attribute_not_exists(secret.verify_attempts) OR secret.verify_attempts < :max)"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedNew),
+		TableName:                 aws.String(client.table),
+	})
+	if isConditionFailed(err) {
+		return ErrTooManyAttempts
+	}
+	if err != nil {
+		glog.Warningf("Error counting verify attempt of %s: %v", user.UserName, err)
+		return err
+	}
+	counted := schema.Secret{}
+	if secret := result.Attributes["secret"]; secret != nil {
+		err = dynamodbattribute.UnmarshalMap(secret.M, &counted)
+		if err != nil {
+			return err
+		}
+	}
+	user.Secret.VerifyAttempts = counted.VerifyAttempts
+	user.Secret.AttemptsSince = counted.AttemptsSince
+	user.Version++
+	return nil
+}
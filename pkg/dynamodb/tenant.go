@@ -0,0 +1,118 @@
+package dynamo
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/codemk8/muser/pkg/blacklist"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/golang/glog"
+)
+
+// Tenants share the table: the key of an item of tenant "acme" is prefixed
+// by "acme/" and the item has a "tenant" attribute for scans. Items of the
+// default tenant keep their plain key and have no "tenant" attribute.
+
+const kindTenant = "tenant"
+
+// globalKinds are the records shared by all tenants
+var globalKinds = map[string]bool{
+	kindTenant:           true,
+	kindOutbox:           true,
+	kindDeadLetter:       true,
+	kindBlacklist:        true,
+	kindBlacklistVersion: true,
+}
+
+// ForTenant returns a client scoped to the tenant, nil is the default tenant
+func (client DynamoClient) ForTenant(tenant *schema.Tenant) *DynamoClient {
+	client.tenant = ""
+	client.tenantBlacklist = nil
+	if tenant != nil {
+		client.tenant = tenant.Name
+		m, err := blacklist.Compile(tenant.Blacklist)
+		if err != nil {
+			// patterns are checked when the tenant is saved
+			glog.Warningf("Bad blacklist of tenant %s: %v", tenant.Name, err)
+		}
+		client.tenantBlacklist = m
+	}
+	return &client
+}
+
+// Tenant returns the name of the tenant the client is scoped to
+func (client DynamoClient) Tenant() string {
+	return client.tenant
+}
+
+// key qualifies a user name or record key with the tenant
+func (client DynamoClient) key(name string) string {
+	if client.tenant == "" {
+		return name
+	}
+	return client.tenant + "/" + name
+}
+
+// keyAttr is the table key of a user name or record key
+func (client DynamoClient) keyAttr(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"user_name": {S: aws.String(client.key(name))},
+	}
+}
+
+// tagTenant adds the tenant attribute to an item of a non default tenant
+func (client DynamoClient) tagTenant(item map[string]*dynamodb.AttributeValue) {
+	if client.tenant != "" {
+		item["tenant"] = &dynamodb.AttributeValue{S: aws.String(client.tenant)}
+	}
+}
+
+// tenantFilter limits scans to the items of the tenant
+func (client DynamoClient) tenantFilter() expression.ConditionBuilder {
+	if client.tenant == "" {
+		return expression.Name("tenant").AttributeNotExists()
+	}
+	return expression.Name("tenant").Equal(expression.Value(client.tenant))
+}
+
+// CreateTenant stores a new tenant, or returns ErrExists
+func (client DynamoClient) CreateTenant(tenant *schema.Tenant) error {
+	item, err := client.recordItem(kindTenant, tenant.Name, tenant)
+	if err != nil {
+		return err
+	}
+	_, err = client.svc.PutItem(&dynamodb.PutItemInput{
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(user_name)"),
+		TableName:           aws.String(client.table),
+	})
+	if isConditionFailed(err) {
+		return ErrExists
+	}
+	if err != nil {
+		glog.Warningf("Error creating tenant %s: %v", tenant.Name, err)
+	}
+	return err
+}
+
+// GetTenant returns a tenant or ErrNotFound
+func (client DynamoClient) GetTenant(name string) (*schema.Tenant, error) {
+	tenant := schema.Tenant{}
+	err := client.getRecord(kindTenant, name, &tenant)
+	if err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// UpdateTenant overwrites the settings of a tenant
+func (client DynamoClient) UpdateTenant(tenant *schema.Tenant) error {
+	return client.putRecord(kindTenant, tenant.Name, tenant)
+}
+
+// ListTenants returns all tenants
+func (client DynamoClient) ListTenants() ([]schema.Tenant, error) {
+	tenants := []schema.Tenant{}
+	err := client.queryRecords(kindTenant, &tenants)
+	return tenants, err
+}
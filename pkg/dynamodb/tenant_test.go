@@ -0,0 +1,28 @@
+package dynamo
+
+import (
+	"testing"
+
+	"github.com/codemk8/muser/pkg/blacklist"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantKeys(t *testing.T) {
+	client := DynamoClient{blacklist: blacklist.New(Blacklist[:])}
+	assert.Equal(t, client.key("test_user"), "test_user", "default tenant keeps plain keys")
+	assert.Equal(t, client.recordKey(kindGroup, "eng"), "#group#eng")
+
+	tenant := schema.NewTenant("acme")
+	tenant.Blacklist = []string{"acme"}
+	scoped := client.ForTenant(tenant)
+	assert.Equal(t, scoped.key("test_user"), "acme/test_user")
+	assert.Equal(t, scoped.recordKey(kindGroup, "eng"), "acme/#group#eng", "groups belong to the tenant")
+	assert.Equal(t, scoped.recordKey(kindOutbox, "1"), "#outbox#1", "the outbox is global")
+	assert.Equal(t, client.key("test_user"), "test_user", "the default client is not changed")
+
+	assert.Equal(t, scoped.BadUserName("acme"), true, "tenant blacklist")
+	assert.Equal(t, scoped.BadUserName("google"), true, "global blacklist")
+	assert.Equal(t, client.BadUserName("acme"), false, "only reserved in the tenant")
+	assert.Equal(t, client.BadUserName("acme/test_user"), true, "no tenant separator in names")
+}
@@ -0,0 +1,76 @@
+package dynamo
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/codemk8/muser/pkg/schema"
+)
+
+// UserFilter narrows ListUsers, zero fields match every user
+type UserFilter struct {
+	// Prefix of the user name
+	Prefix string
+	// EmailPrefix of the profile email
+	EmailPrefix string
+	// Status of the account, StatusActive also matches no status
+	Status string
+	// Verified selects users whose email is verified or not
+	Verified *bool
+	// CreatedAfter and CreatedBefore bound the creation time, unix seconds,
+	// both inclusive
+	CreatedAfter  int64
+	CreatedBefore int64
+}
+
+// condition is the scan filter of f, records are never users
+func (f UserFilter) condition(client DynamoClient) expression.ConditionBuilder {
+	cond := expression.Name("kind").AttributeNotExists()
+	if f.Prefix != "" {
+		cond = cond.And(expression.Name("user_name").BeginsWith(client.key(f.Prefix)))
+	}
+	if f.EmailPrefix != "" {
+		cond = cond.And(expression.Name("profile.email").BeginsWith(f.EmailPrefix))
+	}
+	if f.Status == schema.StatusActive {
+		cond = cond.And(expression.Or(expression.Name("status").AttributeNotExists(),
+			expression.Name("status").Equal(expression.Value(f.Status))))
+	} else if f.Status != "" {
+		cond = cond.And(expression.Name("status").Equal(expression.Value(f.Status)))
+	}
+	if f.Verified != nil {
+		verified := expression.Name("profile.verified").Equal(expression.Value(true))
+		if !*f.Verified {
+			verified = expression.Not(verified)
+		}
+		cond = cond.And(verified)
+	}
+	if f.CreatedAfter > 0 {
+		cond = cond.And(expression.Name("created").GreaterThanEqual(expression.Value(f.CreatedAfter)))
+	}
+	if f.CreatedBefore > 0 {
+		cond = cond.And(expression.Name("created").LessThanEqual(expression.Value(f.CreatedBefore)))
+	}
+	return cond
+}
+
+// ListUsers returns a page of the users of the tenant matching filter,
+// without secrets, and the cursor of the next page
+func (client DynamoClient) ListUsers(filter UserFilter, cursor string, limit int) ([]schema.User, string, error) {
+	proj := userProjection(false)
+	items, next, err := client.scanPage(filter.condition(client), &proj, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	users := []schema.User{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &users)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range users {
+		// the key is qualified by the tenant
+		users[i].UserName = strings.TrimPrefix(users[i].UserName, client.key(""))
+	}
+	return users, next, nil
+}
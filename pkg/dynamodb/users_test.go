@@ -0,0 +1,57 @@
+package dynamo
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/codemk8/muser/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFilter(t *testing.T) {
+	client := DynamoClient{}
+	expr, err := expression.NewBuilder().WithFilter(UserFilter{}.condition(client)).Build()
+	assert.Nil(t, err)
+	assert.Equal(t, len(expr.Values()), 0, "no filter matches every user")
+
+	verified := false
+	filter := UserFilter{Prefix: "test", EmailPrefix: "a@", Verified: &verified, CreatedAfter: 10, CreatedBefore: 20}
+	scoped := client.ForTenant(schema.NewTenant("acme"))
+	expr, err = expression.NewBuilder().WithFilter(filter.condition(*scoped)).Build()
+	assert.Nil(t, err)
+	values := []string{}
+	for _, v := range expr.Values() {
+		if v.S != nil {
+			values = append(values, aws.StringValue(v.S))
+		}
+	}
+	assert.ElementsMatch(t, values, []string{"acme/test", "a@"}, "the prefix is qualified by the tenant")
+	assert.Equal(t, len(expr.Values()), 5)
+
+	expr, err = expression.NewBuilder().WithFilter(UserFilter{Status: schema.StatusDeleted}.condition(client)).Build()
+	assert.Nil(t, err)
+	assert.Equal(t, len(expr.Values()), 1)
+}
+
+func TestUserVersion(t *testing.T) {
+	client := DynamoClient{}
+	user := schema.NewUser("test_user", "hash")
+	item, err := client.userItem(user)
+	assert.Nil(t, err)
+	assert.Equal(t, aws.StringValue(item["version"].N), "1", "the write after the read")
+	cond, values := versionCondition(user)
+	assert.Equal(t, cond, "attribute_exists(user_name) AND attribute_not_exists(version)", "never versioned")
+	assert.Equal(t, len(values), 0)
+
+	user.Version = 7
+	cond, values = versionCondition(user)
+	assert.Equal(t, cond, "attribute_exists(user_name) AND version = :version")
+	assert.Equal(t, aws.StringValue(values[":version"].N), "7")
+
+	update, values := bumpVersion("SET roles = :r", nil)
+	assert.Equal(t, update, "SET roles = :r ADD version :one")
+	assert.Equal(t, aws.StringValue(values[":one"].N), "1")
+	update, _ = bumpVersion("SET #s = :s REMOVE status_until ADD secret.failed_logins :one", nil)
+	assert.Equal(t, update, "SET #s = :s REMOVE status_until ADD version :one, secret.failed_logins :one", "one ADD clause")
+}
@@ -0,0 +1,201 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Types of custom attributes
+const (
+	AttrString = "string"
+	AttrNumber = "number"
+	AttrBool   = "bool"
+)
+
+// Visibility of custom attributes
+const (
+	// only the user and admins see the attribute, the default
+	VisibilityPrivate = "private"
+	// every user sees the attribute
+	VisibilityPublic = "public"
+)
+
+var attributeNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// AttributeDef declares a custom profile attribute
+type AttributeDef struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+	// MaxLength limits the characters of strings, 0 is no limit
+	MaxLength int `json:"max_length,omitempty"`
+	// Pattern is a regular expression strings must match
+	Pattern    string `json:"pattern,omitempty"`
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// Validate checks the definition
+func (def AttributeDef) Validate() error {
+	return validation.ValidateStruct(&def,
+		validation.Field(&def.Name, validation.Required, validation.Match(attributeNameRegexp)),
+		validation.Field(&def.Type, validation.Required, validation.In(AttrString, AttrNumber, AttrBool)),
+		validation.Field(&def.MaxLength, validation.Min(0)),
+		validation.Field(&def.Pattern, validation.By(validRegexp)),
+		validation.Field(&def.Visibility, validation.In(VisibilityPrivate, VisibilityPublic)),
+	)
+}
+
+func validRegexp(value interface{}) error {
+	_, err := regexp.Compile(value.(string))
+	return err
+}
+
+// Public tells if every user sees the attribute
+func (def AttributeDef) Public() bool {
+	return def.Visibility == VisibilityPublic
+}
+
+// validateValue checks a value of the attribute
+func (def AttributeDef) validateValue(value interface{}) error {
+	switch def.Type {
+	case AttrString:
+		s, ok := value.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		rules := []validation.Rule{}
+		if def.Required {
+			rules = append(rules, validation.Required)
+		}
+		if def.MaxLength > 0 {
+			rules = append(rules, validation.RuneLength(0, def.MaxLength))
+		}
+		if def.Pattern != "" {
+			pattern, err := regexp.Compile(def.Pattern)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, validation.Match(pattern))
+		}
+		return validation.Validate(s, rules...)
+	case AttrNumber:
+		// json numbers, read back from the table as well
+		if _, ok := value.(float64); !ok {
+			return errors.New("must be a number")
+		}
+	case AttrBool:
+		if _, ok := value.(bool); !ok {
+			return errors.New("must be a boolean")
+		}
+	}
+	return nil
+}
+
+// AttributeSchema declares the custom attributes profiles can have
+type AttributeSchema []AttributeDef
+
+// Validate checks the definitions, names must be unique
+func (s AttributeSchema) Validate() error {
+	seen := map[string]bool{}
+	for _, def := range s {
+		err := def.Validate()
+		if err != nil {
+			return fmt.Errorf("attribute %q: %v", def.Name, err)
+		}
+		if seen[def.Name] {
+			return fmt.Errorf("attribute %q is declared twice", def.Name)
+		}
+		seen[def.Name] = true
+	}
+	return nil
+}
+
+func (s AttributeSchema) find(name string) *AttributeDef {
+	for i := range s {
+		if s[i].Name == name {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// ValidateValues checks attribute values against the schema, the errors
+// are keyed by attribute name
+func (s AttributeSchema) ValidateValues(values map[string]interface{}) error {
+	errs := validation.Errors{}
+	for name := range values {
+		if s.find(name) == nil {
+			errs[name] = errors.New("unknown attribute")
+		}
+	}
+	for _, def := range s {
+		value, ok := values[def.Name]
+		if !ok || value == nil {
+			if def.Required {
+				errs[def.Name] = errors.New("cannot be blank")
+			}
+			continue
+		}
+		err := def.validateValue(value)
+		if err != nil {
+			errs[def.Name] = err
+		}
+	}
+	return errs.Filter()
+}
+
+// Merge applies an update to the current values, a null value removes the
+// attribute. Values of attributes no longer declared are dropped.
+func (s AttributeSchema) Merge(current map[string]interface{}, update map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for name, value := range current {
+		if s.find(name) != nil {
+			merged[name] = value
+		}
+	}
+	for name, value := range update {
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+// Public returns the values of public attributes
+func (s AttributeSchema) Public(values map[string]interface{}) map[string]interface{} {
+	public := map[string]interface{}{}
+	for name, value := range values {
+		if def := s.find(name); def != nil && def.Public() {
+			public[name] = value
+		}
+	}
+	if len(public) == 0 {
+		return nil
+	}
+	return public
+}
+
+// LoadAttributeSchema reads a json list of attribute definitions
+func LoadAttributeSchema(path string) (AttributeSchema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := AttributeSchema{}
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("bad attribute schema %s: %v", path, err)
+	}
+	err = s.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("bad attribute schema %s: %v", path, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAttributes = AttributeSchema{
+	{Name: "timezone", Type: AttrString, Required: true, MaxLength: 32, Visibility: VisibilityPublic},
+	{Name: "employee_id", Type: AttrString, Pattern: `^E[0-9]+$`},
+	{Name: "age", Type: AttrNumber},
+	{Name: "newsletter", Type: AttrBool},
+}
+
+func TestAttributeSchema(t *testing.T) {
+	assert.Equal(t, testAttributes.Validate(), nil, "a valid schema")
+	assert.NotEqual(t, AttributeSchema{{Name: "Bad Name", Type: AttrString}}.Validate(), nil, "bad name")
+	assert.NotEqual(t, AttributeSchema{{Name: "color", Type: "rgb"}}.Validate(), nil, "unknown type")
+	assert.NotEqual(t, AttributeSchema{{Name: "code", Type: AttrString, Pattern: "("}}.Validate(), nil, "bad pattern")
+	assert.NotEqual(t, AttributeSchema{{Name: "code", Type: AttrString, Visibility: "friends"}}.Validate(), nil, "unknown visibility")
+	assert.NotEqual(t, AttributeSchema{{Name: "code", Type: AttrString}, {Name: "code", Type: AttrBool}}.Validate(), nil, "declared twice")
+}
+
+func TestValidateValues(t *testing.T) {
+	values := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{"timezone": "Europe/Paris", "employee_id": "E42", "age": 42, "newsletter": true}`), &values)
+	assert.Equal(t, err, nil, "json values")
+	assert.Equal(t, testAttributes.ValidateValues(values), nil, "valid values")
+
+	cases := map[string]map[string]interface{}{
+		"required": {"employee_id": "E42"},
+		"too long": {"timezone": "America/Argentina/ComodRivadavia/Too/Long"},
+		"pattern":  {"timezone": "UTC", "employee_id": "42"},
+		"type":     {"timezone": "UTC", "age": "42"},
+		"bool":     {"timezone": "UTC", "newsletter": "yes"},
+		"unknown":  {"timezone": "UTC", "shoe_size": 42.0},
+	}
+	for name, values := range cases {
+		assert.NotEqual(t, testAttributes.ValidateValues(values), nil, name)
+	}
+}
+
+func TestMergeAttributes(t *testing.T) {
+	current := map[string]interface{}{"timezone": "UTC", "age": 41.0, "removed": "x"}
+	merged := testAttributes.Merge(current, map[string]interface{}{"age": 42.0, "timezone": nil, "newsletter": true})
+	assert.Equal(t, merged, map[string]interface{}{"age": 42.0, "newsletter": true}, "null removes, undeclared dropped")
+	assert.Equal(t, testAttributes.Public(current), map[string]interface{}{"timezone": "UTC"}, "public only")
+	assert.Equal(t, testAttributes.Public(merged) == nil, true, "nothing public")
+}
@@ -0,0 +1,9 @@
+package schema
+
+// BlacklistEntry is a reserved name or pattern added at runtime, see
+// package blacklist for the syntax
+type BlacklistEntry struct {
+	Pattern   string `json:"pattern"`
+	CreatedBy string `json:"created_by,omitempty"`
+	Created   int64  `json:"created"`
+}
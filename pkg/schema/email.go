@@ -0,0 +1,29 @@
+package schema
+
+import (
+	"strings"
+)
+
+// EmailClaim makes an email belong to one user of a tenant, it is keyed by
+// the normalized email
+type EmailClaim struct {
+	Email   string `json:"email"`
+	Owner   string `json:"owner"`
+	Created int64  `json:"created"`
+}
+
+// NormalizeEmail is the form emails are compared in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// HoldsEmail tells if the user may keep its claim on email at now: only a
+// verified email is claimed, and the one it replaced while the change can
+// be reverted
+func (user *User) HoldsEmail(email string, now int64) bool {
+	email = NormalizeEmail(email)
+	if NormalizeEmail(user.Profile.Email) == email {
+		return user.Profile.Verified
+	}
+	return NormalizeEmail(user.Secret.RevertEmail) == email && now < user.Secret.RevertExpiry
+}
@@ -0,0 +1,32 @@
+package schema
+
+import (
+	"regexp"
+	"time"
+)
+
+// GroupNameRegexp is the allowed format of group names
+var GroupNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{2,63}$`)
+
+// Group is a named set of users, the members are stored as Membership
+// records and in the Groups of each user
+type Group struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Created     int64  `json:"created,omitempty"`
+}
+
+// Membership links a user to a group
+type Membership struct {
+	Group  string `json:"group"`
+	Member string `json:"member"`
+	Added  int64  `json:"added,omitempty"`
+}
+
+func NewGroup(name string, description string) *Group {
+	return &Group{
+		Name:        name,
+		Description: description,
+		Created:     time.Now().Unix(),
+	}
+}
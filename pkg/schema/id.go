@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// User IDs are UUIDv7 (RFC 9562): they never change, unlike user names,
+// and sort by creation time.
+
+var userIDRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// NewUserID generates a new user ID
+func NewUserID() string {
+	return newUUIDv7(time.Now())
+}
+
+func newUUIDv7(now time.Time) string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b[6:])
+	if err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	ms := make([]byte, 8)
+	binary.BigEndian.PutUint64(ms, uint64(now.UnixNano()/int64(time.Millisecond)))
+	copy(b[:6], ms[2:])
+	b[6] = 0x70 | b[6]&0x0f
+	b[8] = 0x80 | b[8]&0x3f
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ValidUserID tells if id can be a user ID
+func ValidUserID(id string) bool {
+	return userIDRegexp.MatchString(id)
+}
+
+// UserRef points a user ID to the current name of the user, it is keyed by
+// the ID
+type UserRef struct {
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	Created int64  `json:"created"`
+}
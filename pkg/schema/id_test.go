@@ -0,0 +1,21 @@
+package schema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUserID(t *testing.T) {
+	id := NewUserID()
+	assert.Equal(t, ValidUserID(id), true, "a version 7 uuid")
+	assert.Equal(t, id == NewUserID(), false, "random")
+	assert.Equal(t, ValidUserID("not-an-id"), false, "not a uuid")
+	assert.Equal(t, ValidUserID("123e4567-e89b-42d3-a456-426614174000"), false, "version 4")
+
+	earlier := newUUIDv7(time.Unix(1600000000, 0))
+	later := newUUIDv7(time.Unix(1600000001, 0))
+	assert.Equal(t, earlier < later, true, "sorted by creation time")
+	assert.Equal(t, earlier[:13], "0174876e-8000", "unix milliseconds first")
+}
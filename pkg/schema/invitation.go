@@ -0,0 +1,39 @@
+package schema
+
+import (
+	"time"
+)
+
+// Invitation lets the holder of its token register while registration is
+// invite only. It is keyed by the hash of the token sent to Email.
+type Invitation struct {
+	TokenHash string `json:"token_hash"`
+	Email     string `json:"email"`
+	// Role granted to the invitee on registration, if any
+	Role      string `json:"role,omitempty"`
+	InvitedBy string `json:"invited_by"`
+	Created   int64  `json:"created"`
+	Expiry    int64  `json:"expiry"`
+	Used      bool   `json:"used,omitempty"`
+	UsedBy    string `json:"used_by,omitempty"`
+}
+
+// NewInvitation creates an invitation valid for validFor and returns it
+// with the plain token to send to the invitee
+func NewInvitation(email string, role string, invitedBy string, validFor time.Duration) (*Invitation, string) {
+	token := GenToken()
+	now := time.Now()
+	return &Invitation{
+		TokenHash: HashToken(token),
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		Created:   now.Unix(),
+		Expiry:    now.Add(validFor).Unix(),
+	}, token
+}
+
+// Valid returns true if the invitation can still be used
+func (inv *Invitation) Valid() bool {
+	return !inv.Used && time.Now().Unix() < inv.Expiry
+}
@@ -0,0 +1,32 @@
+package schema
+
+import (
+	"time"
+)
+
+// Reservation keeps a user name from being registered, e.g. after its
+// account was deleted
+type Reservation struct {
+	Name    string `json:"name"`
+	Reason  string `json:"reason,omitempty"`
+	Created int64  `json:"created"`
+	// Until is when the name is free again, 0 is never
+	Until int64 `json:"until,omitempty"`
+	// RedirectTo is the new name of a renamed user
+	RedirectTo string `json:"redirect_to,omitempty"`
+}
+
+// NewReservation reserves name for period, 0 is forever
+func NewReservation(name string, reason string, period time.Duration) *Reservation {
+	now := time.Now()
+	res := &Reservation{Name: name, Reason: reason, Created: now.Unix()}
+	if period > 0 {
+		res.Until = now.Add(period).Unix()
+	}
+	return res
+}
+
+// Active tells if the name is still reserved
+func (res *Reservation) Active() bool {
+	return res.Until == 0 || time.Now().Unix() < res.Until
+}
@@ -0,0 +1,68 @@
+package schema
+
+// Roles granted to users, every user implicitly has RoleUser
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Permissions checked on routes
+const (
+	// read the profile of other users
+	PermUserRead = "user:read"
+	// list groups and their members
+	PermGroupsRead = "groups:read"
+	// create, change and delete groups and their members
+	PermGroupsManage = "groups:manage"
+	// list, inspect and manage all users
+	PermUsersAdmin = "users:admin"
+	// invite users without using an invite quota
+	PermInvitationsCreate = "invitations:create"
+	// grant and revoke roles
+	PermRolesManage = "roles:manage"
+	// create and configure tenants, only in the default tenant
+	PermTenantsManage = "tenants:manage"
+	// inspect and replay webhook dead letters
+	PermWebhooksManage = "webhooks:manage"
+	// add and remove blacklisted names, only in the default tenant
+	PermBlacklistManage = "blacklist:manage"
+	// PermAll grants every permission
+	PermAll = "*"
+)
+
+// RolePermissions lists the permissions of each role
+var RolePermissions = map[string][]string{
+	RoleAdmin: {PermAll},
+	RoleUser:  {PermUserRead, PermGroupsRead},
+}
+
+// ValidRole returns true for the roles known in RolePermissions
+func ValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// HasRole returns true if the user has been granted the role
+func (user *User) HasRole(role string) bool {
+	if role == RoleUser {
+		return true
+	}
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission returns true if any role of the user grants perm
+func (user *User) HasPermission(perm string) bool {
+	for _, role := range append([]string{RoleUser}, user.Roles...) {
+		for _, p := range RolePermissions[role] {
+			if p == perm || p == PermAll {
+				return true
+			}
+		}
+	}
+	return false
+}
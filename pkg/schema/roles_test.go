@@ -0,0 +1,19 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissions(t *testing.T) {
+	user := NewUser("test_user", "")
+	assert.Equal(t, user.HasRole(RoleUser), true, "every user is a user")
+	assert.Equal(t, user.HasRole(RoleAdmin), false)
+	assert.Equal(t, user.HasPermission(PermUserRead), true)
+	assert.Equal(t, user.HasPermission(PermRolesManage), false)
+
+	user.Roles = []string{RoleAdmin}
+	assert.Equal(t, user.HasPermission(PermRolesManage), true, "admin has all permissions")
+	assert.Equal(t, ValidRole("root"), false)
+}
@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"regexp"
+	"time"
+)
+
+// TenantNameRegexp is the allowed format of tenant names, they show up in
+// host names and paths
+var TenantNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+// Tenant is an organization with its own namespace of users and groups.
+// Users of the default tenant (empty name) live outside any organization.
+type Tenant struct {
+	Name string `json:"name"`
+	// Hosts resolving to this tenant besides <name>.<domain>
+	Hosts []string `json:"hosts,omitempty"`
+	// Blacklist holds user names reserved in this tenant only
+	Blacklist []string       `json:"blacklist,omitempty"`
+	Settings  TenantSettings `json:"settings"`
+	Created   int64          `json:"created,omitempty"`
+}
+
+// TenantSettings are the per tenant policies
+type TenantSettings struct {
+	// DisableRegistration rejects self registration of new users
+	DisableRegistration bool `json:"disable_registration,omitempty"`
+	// InviteOnly requires an invitation to register
+	InviteOnly bool `json:"invite_only,omitempty"`
+	// RequireVerified requires a verified email to authenticate
+	RequireVerified bool `json:"require_verified,omitempty"`
+	// Attributes replaces the server's custom profile attributes if set
+	Attributes AttributeSchema `json:"attributes,omitempty"`
+}
+
+// Validate checks the settings
+func (settings TenantSettings) Validate() error {
+	return settings.Attributes.Validate()
+}
+
+func NewTenant(name string) *Tenant {
+	return &Tenant{
+		Name:    name,
+		Created: time.Now().Unix(),
+	}
+}
@@ -1,46 +1,281 @@
 package schema
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"time"
 )
 
+// Account status
+const (
+	StatusActive = "active"
+	// blocked by an admin, until StatusUntil if set
+	StatusSuspended = "suspended"
+	// blocked after too many failed logins until StatusUntil
+	StatusLocked = "locked"
+	// blocked until the email is verified
+	StatusPendingVerification = "pending_verification"
+	// deleted by the user, restorable until purged
+	StatusDeleted = "deleted"
+)
+
+// ValidStatus tells if status can be set on an account
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusActive, StatusSuspended, StatusLocked, StatusPendingVerification, StatusDeleted:
+		return true
+	}
+	return false
+}
+
+// MaxVerifyAttempts is the number of verification codes tried within
+// VerifyAttemptsWindow, whatever the codes sent meanwhile
+const MaxVerifyAttempts = 5
+
+// VerifyAttemptsWindow is the period MaxVerifyAttempts applies to, from the
+// first attempt
+const VerifyAttemptsWindow = time.Hour
+
 // A schema for user profile
 // It stores: username, salt, an email and an avatar string
 
 type Profile struct {
 	Email    string `json:"email,omitempty"`
 	Verified bool   `json:"verified"`
-	Avatar   string `json:"avatar,omitempty"`
+	// URL of the largest avatar thumbnail, see /user/avatar
+	Avatar string `json:"avatar,omitempty"`
+	// preferred language of notifications, e.g. "en" or "pt-BR"
+	Locale string `json:"locale,omitempty"`
+	// IANA time zone, e.g. "Europe/Paris"
+	Timezone string `json:"timezone,omitempty"`
+	// Attributes are the custom attributes declared by the AttributeSchema
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
 // Secret group fields hidden from normal access
 type Secret struct {
 	// the password, salted of course
 	Salt string `json:"salt,omitempty"`
-	// used for email verifications
+	// used for email verifications, only the hash of the code is stored
 	VerifyCode string `json:"verify_code,omitempty"`
 	CodeExpiry int64  `json:"expiry,omitempty"`
+	// codes tried since AttemptsSince, unix seconds, see MaxVerifyAttempts
+	VerifyAttempts int   `json:"verify_attempts,omitempty"`
+	AttemptsSince  int64 `json:"attempts_since,omitempty"`
+	// unix timestamp the current code was sent, used for resend cooldown
+	CodeSent int64 `json:"code_sent,omitempty"`
+	// new email waiting for verification before it replaces Profile.Email
+	PendingEmail string `json:"pending_email,omitempty"`
+	// lets the previous address undo an email change, only the hash of the
+	// token is stored
+	RevertToken    string `json:"revert_token,omitempty"`
+	RevertEmail    string `json:"revert_email,omitempty"`
+	RevertVerified bool   `json:"revert_verified,omitempty"`
+	RevertExpiry   int64  `json:"revert_expiry,omitempty"`
+	// set by an admin, the password must be reset with the reset token
+	// before the user can authenticate again
+	MustResetPassword bool   `json:"must_reset,omitempty"`
+	ResetToken        string `json:"reset_token,omitempty"`
+	ResetExpiry       int64  `json:"reset_expiry,omitempty"`
+	// consecutive wrong passwords, the account is locked when it reaches
+	// the configured maximum
+	FailedLogins int `json:"failed_logins,omitempty"`
 }
 
 // User is the user schame in database
 type User struct {
-	UserName string  `json:"user_name,omitempty"`
-	Created  int64   `json:"created,omitempty"`
-	Profile  Profile `json:"profile,omitempty"`
-	Secret   Secret  `json:"secret,omitempty"`
+	// ID never changes, see NewUserID, users created before IDs have none
+	// until they authenticate
+	ID string `json:"id,omitempty"`
+	// UserName is canonical, see CanonicalUserName, DisplayName is the name
+	// as registered
+	UserName    string   `json:"user_name,omitempty"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Created     int64    `json:"created,omitempty"`
+	Profile     Profile  `json:"profile,omitempty"`
+	Secret      Secret   `json:"secret,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	// invitations the user can still send, see Invitation
+	InviteQuota int `json:"invite_quota,omitempty"`
+	// Status of the account, empty is StatusActive
+	Status       string `json:"status,omitempty"`
+	StatusReason string `json:"status_reason,omitempty"`
+	// unix timestamps of the last status change and of the end of a
+	// suspension or lock, 0 is none
+	StatusChanged int64 `json:"status_changed,omitempty"`
+	StatusUntil   int64 `json:"status_until,omitempty"`
+	// unix timestamp of the soft delete, see StatusDeleted
+	DeletedAt int64 `json:"deleted_at,omitempty"`
+	// status and reason before the soft delete, a restore brings them back
+	RestoreStatus string `json:"restore_status,omitempty"`
+	RestoreReason string `json:"restore_reason,omitempty"`
+	// NameHistory lists the renames of the user, oldest first
+	NameHistory []NameChange `json:"name_history,omitempty"`
+	// Version counts the writes of the user, a write of the user as read
+	// fails if it changed since
+	Version int64 `json:"version,omitempty"`
+}
+
+// NameChange records a rename
+type NameChange struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Changed int64  `json:"changed"`
 }
 
 // A helper function to generate a 6-digit verification code with an expiry unit timestamp
 func GenVerifyCodeAndExpiry(expireInMin int) (string, int64) {
-	rand.Seed(time.Now().UnixNano())
-	randNum := rand.Intn(999999)
-	return fmt.Sprintf("%06d", randNum), time.Now().Local().Add(time.Minute * time.Duration(expireInMin)).Unix()
+	randNum, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		// no usable entropy source, nothing sensible can be done
+		panic(fmt.Sprintf("failed to read random number: %v", err))
+	}
+	return fmt.Sprintf("%06d", randNum.Int64()), time.Now().Local().Add(time.Minute * time.Duration(expireInMin)).Unix()
+}
+
+// codeKey keys the hashes of verification codes, see SetCodeKey
+var codeKey []byte
+
+// SetCodeKey sets the server key of verification code hashes: a 6 digits
+// code is brute forced from a plain hash in no time, not without the key
+func SetCodeKey(key []byte) {
+	codeKey = append([]byte{}, key...)
+}
+
+// HashVerifyCode hashes a verification code with an HMAC keyed by the server
+// key, salted with the user name
+func HashVerifyCode(username string, code string) string {
+	mac := hmac.New(sha256.New, codeKey)
+	mac.Write([]byte(username + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenToken generates a random url-safe token for links sent by email
+func GenToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// HashToken hashes a token generated by GenToken for storage
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckToken compares a plain token with a stored hash in constant time
+func CheckToken(token string, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
+
+// SetVerifyCode generates a new verification code, keeps its hash and
+// returns the plain code to be sent to the user. The new code gets the
+// attempts left in the window, a new code must not allow more guesses.
+func (secret *Secret) SetVerifyCode(username string, expireInMin int) string {
+	code, expiry := GenVerifyCodeAndExpiry(expireInMin)
+	secret.VerifyCode = HashVerifyCode(username, code)
+	secret.CodeExpiry = expiry
+	now := time.Now().Unix()
+	if now >= secret.AttemptsSince+int64(VerifyAttemptsWindow/time.Second) {
+		secret.ClearVerifyAttempts()
+	}
+	secret.CodeSent = now
+	return code
+}
+
+// VerifyBlocked tells if no code can be tried until the window of the
+// attempts is over
+func (secret *Secret) VerifyBlocked(now int64) bool {
+	return secret.VerifyAttempts >= MaxVerifyAttempts && now < secret.AttemptsSince+int64(VerifyAttemptsWindow/time.Second)
+}
+
+// ClearVerifyAttempts forgets the codes tried, once the email is verified
+func (secret *Secret) ClearVerifyAttempts() {
+	secret.VerifyAttempts = 0
+	secret.AttemptsSince = 0
+}
+
+// CheckVerifyCode compares a plain code with the stored hash in constant time
+func (secret *Secret) CheckVerifyCode(username string, code string) bool {
+	if secret.VerifyCode == "" {
+		return false
+	}
+	hash := HashVerifyCode(username, code)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(secret.VerifyCode)) == 1
+}
+
+// ClearVerifyCode invalidates the current verification code, the attempts
+// still count against the next one
+func (secret *Secret) ClearVerifyCode() {
+	secret.VerifyCode = ""
+	secret.CodeExpiry = 0
+}
+
+// SetRevert remembers the replaced email so it can be restored within
+// validFor, and returns the plain revert token
+func (secret *Secret) SetRevert(email string, verified bool, validFor time.Duration) string {
+	token := GenToken()
+	secret.RevertToken = HashToken(token)
+	secret.RevertEmail = email
+	secret.RevertVerified = verified
+	secret.RevertExpiry = time.Now().Add(validFor).Unix()
+	return token
+}
+
+// RevertOpen tells if the last email change can still be reverted at now
+func (secret *Secret) RevertOpen(now int64) bool {
+	return secret.RevertToken != "" && now < secret.RevertExpiry
+}
+
+// ClearRevert drops the revert information of the last email change
+func (secret *Secret) ClearRevert() {
+	secret.RevertToken = ""
+	secret.RevertEmail = ""
+	secret.RevertVerified = false
+	secret.RevertExpiry = 0
+}
+
+// CurrentStatus returns the status of the account now, a suspension or
+// lock is over once StatusUntil is past
+func (user *User) CurrentStatus() string {
+	switch user.Status {
+	case "":
+		return StatusActive
+	case StatusSuspended, StatusLocked:
+		if user.StatusUntil > 0 && time.Now().Unix() >= user.StatusUntil {
+			return StatusActive
+		}
+	}
+	return user.Status
+}
+
+// Active returns true if nothing blocks the account
+func (user *User) Active() bool {
+	return user.CurrentStatus() == StatusActive
+}
+
+// Lockable tells if failed logins can lock the account, only active ones
+// can: a lock would replace a suspension, deletion or pending verification,
+// and end with the account active
+func (user *User) Lockable() bool {
+	return user.Active()
 }
 
 func NewUser(username string, salt string) *User {
 	return &User{
+		ID:       NewUserID(),
 		UserName: username,
 		Created:  time.Now().Unix(),
 		Secret: Secret{
@@ -16,3 +16,111 @@ func TestGenVerifyCodeAndExpiry(t *testing.T) {
 	assert.Equal(t, codeExpiry-now > 58, true, "should be ok with a computer")
 	assert.Equal(t, codeExpiry-now < 62, true, "should be ok with a computer")
 }
+
+func TestVerifyCode(t *testing.T) {
+	secret := Secret{}
+	code := secret.SetVerifyCode("test_user", 1)
+	assert.NotEqual(t, secret.VerifyCode, code, "only the hash is stored")
+	assert.Equal(t, secret.CheckVerifyCode("test_user", code), true, "the right code")
+	assert.Equal(t, secret.CheckVerifyCode("other_user", code), false, "salted by user name")
+	SetCodeKey([]byte("another server key"))
+	assert.Equal(t, secret.CheckVerifyCode("test_user", code), false, "keyed by the server key")
+	SetCodeKey(nil)
+	secret.ClearVerifyCode()
+	assert.Equal(t, secret.CheckVerifyCode("test_user", code), false, "code is cleared")
+}
+
+func TestVerifyAttempts(t *testing.T) {
+	now := time.Now().Unix()
+	secret := Secret{VerifyAttempts: MaxVerifyAttempts, AttemptsSince: now - 60}
+	assert.Equal(t, secret.VerifyBlocked(now), true, "no attempt left")
+	secret.SetVerifyCode("test_user", 1)
+	assert.Equal(t, secret.VerifyAttempts, MaxVerifyAttempts, "a new code brings no attempts")
+	secret.ClearVerifyCode()
+	assert.Equal(t, secret.VerifyAttempts, MaxVerifyAttempts, "nor does clearing the code")
+
+	secret.AttemptsSince = now - int64(VerifyAttemptsWindow/time.Second)
+	assert.Equal(t, secret.VerifyBlocked(now), false, "the window is over")
+	secret.SetVerifyCode("test_user", 1)
+	assert.Equal(t, secret.VerifyAttempts, 0, "a new window")
+	assert.Equal(t, secret.AttemptsSince, int64(0), "starting at the next attempt")
+}
+
+func TestRevertToken(t *testing.T) {
+	secret := Secret{}
+	token := secret.SetRevert("old@example.com", true, time.Hour)
+	assert.Equal(t, CheckToken(token, secret.RevertToken), true, "the right token")
+	assert.Equal(t, CheckToken(GenToken(), secret.RevertToken), false, "a random token")
+	now := time.Now().Unix()
+	assert.Equal(t, secret.RevertOpen(now), true, "open within the hour")
+	assert.Equal(t, secret.RevertOpen(now+3600), false, "closed after the hour")
+	secret.ClearRevert()
+	assert.Equal(t, CheckToken(token, secret.RevertToken), false, "token is cleared")
+	assert.Equal(t, secret.RevertOpen(now), false, "closed once cleared")
+}
+
+func TestInvitation(t *testing.T) {
+	inv, token := NewInvitation("test@example.com", "", "admin", time.Hour)
+	assert.Equal(t, inv.TokenHash, HashToken(token), "keyed by the token hash")
+	assert.Equal(t, inv.Valid(), true)
+	inv.Used = true
+	assert.Equal(t, inv.Valid(), false, "single use")
+	inv, _ = NewInvitation("test@example.com", "", "admin", -time.Hour)
+	assert.Equal(t, inv.Valid(), false, "expired")
+}
+
+func TestActive(t *testing.T) {
+	user := NewUser("test_user", "")
+	assert.Equal(t, user.Active(), true, "no status is active")
+	user.Status = StatusSuspended
+	assert.Equal(t, user.Active(), false)
+	user.StatusUntil = time.Now().Add(time.Hour).Unix()
+	assert.Equal(t, user.CurrentStatus(), StatusSuspended, "suspended for an hour")
+	user.Status = StatusLocked
+	user.StatusUntil = time.Now().Add(-time.Hour).Unix()
+	assert.Equal(t, user.CurrentStatus(), StatusActive, "the lock is over")
+	user.Status = StatusPendingVerification
+	assert.Equal(t, user.Active(), false, "verification has no end")
+	assert.Equal(t, ValidStatus(StatusLocked), true)
+	assert.Equal(t, ValidStatus("disabled"), false)
+}
+
+func TestLockable(t *testing.T) {
+	user := NewUser("test_user", "")
+	assert.Equal(t, user.Lockable(), true, "active")
+	user.Status = StatusSuspended
+	assert.Equal(t, user.Lockable(), false, "suspended for ever")
+	user.StatusUntil = time.Now().Add(-time.Minute).Unix()
+	assert.Equal(t, user.Lockable(), true, "the suspension is over")
+	user.StatusUntil = 0
+	user.Status = StatusDeleted
+	assert.Equal(t, user.Lockable(), false, "deleted")
+	user.Status = StatusPendingVerification
+	assert.Equal(t, user.Lockable(), false, "pending verification")
+	user.Status = StatusLocked
+	user.StatusUntil = time.Now().Add(time.Minute).Unix()
+	assert.Equal(t, user.Lockable(), false, "already locked")
+}
+
+func TestReservation(t *testing.T) {
+	assert.Equal(t, NewReservation("test_user", "deleted", 0).Active(), true, "forever")
+	assert.Equal(t, NewReservation("test_user", "deleted", time.Hour).Active(), true)
+	res := Reservation{Name: "test_user", Until: time.Now().Add(-time.Hour).Unix()}
+	assert.Equal(t, res.Active(), false, "expired")
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	assert.Equal(t, NormalizeEmail(" Test@Example.COM "), "test@example.com")
+}
+
+func TestHoldsEmail(t *testing.T) {
+	now := time.Now().Unix()
+	user := User{Profile: Profile{Email: "Test@Example.com"}}
+	assert.Equal(t, user.HoldsEmail("test@example.com", now), false, "unverified emails are not held")
+	user.Profile.Verified = true
+	assert.Equal(t, user.HoldsEmail("test@example.com", now), true, "verified email")
+	user.Secret.SetRevert("old@example.com", true, time.Hour)
+	assert.Equal(t, user.HoldsEmail("old@example.com", now), true, "while the change can be reverted")
+	assert.Equal(t, user.HoldsEmail("old@example.com", now+3600), false, "released after the revert window")
+	assert.Equal(t, user.HoldsEmail("other@example.com", now), false)
+}
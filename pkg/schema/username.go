@@ -0,0 +1,125 @@
+package schema
+
+import (
+	"errors"
+	"unicode"
+
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+// User names are stored in their canonical form, the key of the user, and
+// the form the user typed is kept as DisplayName. Two names with the same
+// canonical form, e.g. "Alice" and "ａｌｉｃｅ", are the same user.
+
+var (
+	// ErrBadUserName is returned for names PRECIS rejects, e.g. with spaces
+	// or control characters
+	ErrBadUserName = errors.New("user name has characters not allowed")
+	// ErrMixedScript is returned for names mixing scripts, e.g. Latin and
+	// Cyrillic, a usual way to imitate another name
+	ErrMixedScript = errors.New("user name mixes scripts")
+	// ErrConfusable is returned for names written entirely with letters
+	// looking like Latin ones in another script that pass for a taken or
+	// blacklisted Latin name, e.g. Cyrillic "асе" once "ace" is taken
+	ErrConfusable = errors.New("user name looks like a Latin name")
+)
+
+// scriptTables are the scripts told apart, letters of other scripts are
+// not restricted
+var scriptTables = map[string]*unicode.RangeTable{
+	"Latin":    unicode.Latin,
+	"Cyrillic": unicode.Cyrillic,
+	"Greek":    unicode.Greek,
+	"Armenian": unicode.Armenian,
+	"Han":      unicode.Han,
+	"Hiragana": unicode.Hiragana,
+	"Katakana": unicode.Katakana,
+	"Hangul":   unicode.Hangul,
+	"Arabic":   unicode.Arabic,
+	"Hebrew":   unicode.Hebrew,
+	"Thai":     unicode.Thai,
+}
+
+// scriptSets are scripts legitimately written together
+var scriptSets = []map[string]bool{
+	{"Han": true, "Hiragana": true, "Katakana": true},
+	{"Han": true, "Hangul": true},
+}
+
+// latinLookalikes are the Cyrillic and Greek letters, after case folding,
+// that can pass for Latin ones, and the letter they pass for
+var latinLookalikes = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'һ': 'h',
+	'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+// scripts returns the scripts of the letters of name
+func scripts(name string) map[string]bool {
+	found := map[string]bool{}
+	for _, r := range name {
+		for script, table := range scriptTables {
+			if unicode.Is(table, r) {
+				found[script] = true
+				break
+			}
+		}
+	}
+	return found
+}
+
+// mixedScripts tells if name has letters of scripts not written together
+func mixedScripts(name string) bool {
+	found := scripts(name)
+	if len(found) <= 1 {
+		return false
+	}
+	for _, set := range scriptSets {
+		ok := true
+		for script := range found {
+			ok = ok && set[script]
+		}
+		if ok {
+			return false
+		}
+	}
+	return true
+}
+
+// LatinSkeleton returns the Latin name a canonical name passes for, and
+// false if some of its letters don't pass for Latin ones. "асе" in Cyrillic
+// passes for "ace", which only matters if "ace" is taken or blacklisted.
+func LatinSkeleton(name string) (string, bool) {
+	skeleton := []rune{}
+	letters := 0
+	for _, r := range name {
+		if unicode.IsLetter(r) {
+			latin, ok := latinLookalikes[r]
+			if !ok {
+				return "", false
+			}
+			r = latin
+			letters++
+		}
+		skeleton = append(skeleton, r)
+	}
+	return string(skeleton), letters > 0
+}
+
+// CanonicalUserName returns the canonical form of a user name: NFKC
+// normalized and case folded by the PRECIS UsernameCaseMapped profile of
+// RFC 8265. Names mixing scripts are rejected, names passing for Latin ones
+// are checked against the taken names, see LatinSkeleton.
+func CanonicalUserName(name string) (string, error) {
+	canonical, err := precis.UsernameCaseMapped.String(norm.NFKC.String(name))
+	if err != nil {
+		return "", ErrBadUserName
+	}
+	if mixedScripts(canonical) {
+		return "", ErrMixedScript
+	}
+	return canonical, nil
+}
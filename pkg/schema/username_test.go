@@ -0,0 +1,43 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalUserName(t *testing.T) {
+	for name, canonical := range map[string]string{
+		"alice":     "alice",
+		"Alice":     "alice",
+		"ＡＬＩＣＥ":     "alice",
+		"test_user": "test_user",
+		"Ünïcödé":   "ünïcödé",
+		"Ивaн":      "",
+		"иван":      "иван",
+		"аdmin":     "",
+		"асе":       "асе",
+		"окно":      "окно",
+		"山田たろう":     "山田たろう",
+		"bad name":  "",
+	} {
+		got, err := CanonicalUserName(name)
+		assert.Equal(t, got, canonical, name)
+		assert.Equal(t, err != nil, canonical == "", name)
+	}
+	_, err := CanonicalUserName("Ивaн")
+	assert.Equal(t, err, ErrMixedScript, "Cyrillic with a Latin a")
+}
+
+func TestLatinSkeleton(t *testing.T) {
+	skeleton, ok := LatinSkeleton("асе")
+	assert.Equal(t, ok, true, "Cyrillic looking like ace")
+	assert.Equal(t, skeleton, "ace")
+	skeleton, ok = LatinSkeleton("сок_1")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, skeleton, "cok_1", "other characters are kept")
+	_, ok = LatinSkeleton("иван")
+	assert.Equal(t, ok, false, "и passes for no Latin letter")
+	_, ok = LatinSkeleton("123")
+	assert.Equal(t, ok, false, "no letters")
+}
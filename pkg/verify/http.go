@@ -12,14 +12,15 @@ import (
 	"github.com/go-resty/resty/v2"
 )
 
-func SendVerifyEmail(emailEndpoint string, username string, email string, verifyCode string) error {
-	if emailEndpoint == "" {
+// HTTPNotifier posts the notification as json to an email service
+type HTTPNotifier struct {
+	Endpoint string
+}
+
+func (n HTTPNotifier) Notify(requestJSON VerifyRequest) error {
+	if n.Endpoint == "" {
 		glog.Warning("email svc not configured. skipping sending verifying email")
-	}
-	requestJSON := VerifyRequest{
-		UserName:   username,
-		To:         email,
-		VerifyCode: verifyCode,
+		return nil
 	}
 	requestBody, err := json.Marshal(requestJSON)
 	if err != nil {
@@ -28,7 +29,7 @@ func SendVerifyEmail(emailEndpoint string, username string, email string, verify
 	}
 	client := resty.New()
 	request := client.R().SetHeader("Content-Type", "application/json").SetBody(requestBody)
-	resp, err := request.Post(emailEndpoint)
+	resp, err := request.Post(n.Endpoint)
 	if err != nil {
 		glog.Warningf("error post to email service %v", err)
 		return err
@@ -40,6 +41,6 @@ func SendVerifyEmail(emailEndpoint string, username string, email string, verify
 		glog.Warningf("Error sending email: %s", string(resp.Body()))
 		return errors.New("remote API error response: " + string(resp.Body()))
 	}
-	glog.Warningf("Error sending email, error code %d", strconv.Itoa(resp.StatusCode()))
+	glog.Warningf("Error sending email, error code %d", resp.StatusCode())
 	return errors.New("remote API error code " + strconv.Itoa(resp.StatusCode()))
 }
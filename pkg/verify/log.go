@@ -0,0 +1,44 @@
+package verify
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// LogNotifier records notifications in a file or the log instead of
+// delivering them, meant for development
+type LogNotifier struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewLogNotifier appends notifications to path as json lines, or logs them
+// with glog if path is empty
+func NewLogNotifier(path string) (*LogNotifier, error) {
+	if path == "" {
+		return &LogNotifier{}, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &LogNotifier{file: file}, nil
+}
+
+func (n *LogNotifier) Notify(req VerifyRequest) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if n.file == nil {
+		glog.Infof("Notification: %s", line)
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err = n.file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,132 @@
+package verify
+
+// builtinMessages are the default templates by locale and notification type.
+// Templates are executed with the VerifyRequest being sent, times are
+// written with the date function in the layout of the locale. Alerts describe
+// their Event in the locale, never with the Detail of the caller.
+var builtinMessages = map[string]map[string]message{
+	"en": {
+		TypeVerify: {
+			Subject: "Verify your email",
+			Text:    "Hi {{.UserName}},\n\nYour verification code is {{.VerifyCode}}.\n",
+			HTML:    "<p>Hi {{.UserName}},</p><p>Your verification code is <b>{{.VerifyCode}}</b>.</p>",
+		},
+		TypeEmailChanged: {
+			Subject: "Your email was changed",
+			Text: "Hi {{.UserName}},\n\nThe email of your account was changed to {{.NewEmail}}.\n" +
+				"{{if .RevertLink}}If you did not make this change, revert it with the link below:\n\n{{.RevertLink}}\n{{end}}",
+			HTML: "<p>Hi {{.UserName}},</p><p>The email of your account was changed to {{.NewEmail}}.</p>" +
+				"{{if .RevertLink}}<p>If you did not make this change, <a href=\"{{.RevertLink}}\">revert it</a>.</p>{{end}}",
+		},
+		TypePasswordReset: {
+			Subject: "Reset your password",
+			Text:    "Hi {{.UserName}},\n\nReset your password with the link below:\n\n{{.Link}}\n",
+			HTML:    "<p>Hi {{.UserName}},</p><p><a href=\"{{.Link}}\">Reset your password</a>.</p>",
+		},
+		TypeLockout: {
+			Subject: "Your account is locked",
+			Text: "Hi {{.UserName}},\n\nYour account was locked after too many failed sign in attempts. " +
+				"It will be unlocked on {{date .Time}}.\n",
+			HTML: "<p>Hi {{.UserName}},</p><p>Your account was locked after too many failed sign in attempts. " +
+				"It will be unlocked on {{date .Time}}.</p>",
+		},
+		TypeInvitation: {
+			Subject: "You are invited to join",
+			Text:    "Hi,\n\n{{.Detail}} invited you to create an account. Register with the link below:\n\n{{.Link}}\n",
+			HTML:    "<p>Hi,</p><p>{{.Detail}} invited you to create an account. <a href=\"{{.Link}}\">Register now</a>.</p>",
+		},
+		TypeSecurityAlert: {
+			Subject: "Security alert for your account",
+			Text: "Hi {{.UserName}},\n\n" +
+				"{{if eq .Event \"password_changed\"}}The password of your account was changed.{{else if eq .Event \"account_deleted\"}}" +
+				"Your account was deleted, it can be restored until {{date .Time}}.{{else}}A security setting of your account was changed.{{end}}" +
+				"\nIf this was not you, please contact support.\n",
+			HTML: "<p>Hi {{.UserName}},</p><p>" +
+				"{{if eq .Event \"password_changed\"}}The password of your account was changed.{{else if eq .Event \"account_deleted\"}}" +
+				"Your account was deleted, it can be restored until {{date .Time}}.{{else}}A security setting of your account was changed.{{end}}" +
+				"</p><p>If this was not you, please contact support.</p>",
+		},
+	},
+	"es": {
+		TypeVerify: {
+			Subject: "Verifica tu correo",
+			Text:    "Hola {{.UserName}},\n\nTu código de verificación es {{.VerifyCode}}.\n",
+			HTML:    "<p>Hola {{.UserName}},</p><p>Tu código de verificación es <b>{{.VerifyCode}}</b>.</p>",
+		},
+		TypeEmailChanged: {
+			Subject: "Tu correo ha cambiado",
+			Text: "Hola {{.UserName}},\n\nEl correo de tu cuenta se cambió a {{.NewEmail}}.\n" +
+				"{{if .RevertLink}}Si no hiciste este cambio, reviértelo con el siguiente enlace:\n\n{{.RevertLink}}\n{{end}}",
+			HTML: "<p>Hola {{.UserName}},</p><p>El correo de tu cuenta se cambió a {{.NewEmail}}.</p>" +
+				"{{if .RevertLink}}<p>Si no hiciste este cambio, <a href=\"{{.RevertLink}}\">reviértelo</a>.</p>{{end}}",
+		},
+		TypePasswordReset: {
+			Subject: "Restablece tu contraseña",
+			Text:    "Hola {{.UserName}},\n\nRestablece tu contraseña con el siguiente enlace:\n\n{{.Link}}\n",
+			HTML:    "<p>Hola {{.UserName}},</p><p><a href=\"{{.Link}}\">Restablece tu contraseña</a>.</p>",
+		},
+		TypeLockout: {
+			Subject: "Tu cuenta está bloqueada",
+			Text: "Hola {{.UserName}},\n\nTu cuenta se bloqueó tras demasiados intentos fallidos de inicio de sesión. " +
+				"Se desbloqueará el {{date .Time}}.\n",
+			HTML: "<p>Hola {{.UserName}},</p><p>Tu cuenta se bloqueó tras demasiados intentos fallidos de inicio de sesión. " +
+				"Se desbloqueará el {{date .Time}}.</p>",
+		},
+		TypeInvitation: {
+			Subject: "Te han invitado",
+			Text:    "Hola,\n\n{{.Detail}} te invitó a crear una cuenta. Regístrate con el siguiente enlace:\n\n{{.Link}}\n",
+			HTML:    "<p>Hola,</p><p>{{.Detail}} te invitó a crear una cuenta. <a href=\"{{.Link}}\">Regístrate ahora</a>.</p>",
+		},
+		TypeSecurityAlert: {
+			Subject: "Alerta de seguridad de tu cuenta",
+			Text: "Hola {{.UserName}},\n\n" +
+				"{{if eq .Event \"password_changed\"}}La contraseña de tu cuenta ha cambiado.{{else if eq .Event \"account_deleted\"}}" +
+				"Tu cuenta se eliminó, puedes restaurarla hasta el {{date .Time}}.{{else}}Se cambió un ajuste de seguridad de tu cuenta.{{end}}" +
+				"\nSi no fuiste tú, contacta con soporte.\n",
+			HTML: "<p>Hola {{.UserName}},</p><p>" +
+				"{{if eq .Event \"password_changed\"}}La contraseña de tu cuenta ha cambiado.{{else if eq .Event \"account_deleted\"}}" +
+				"Tu cuenta se eliminó, puedes restaurarla hasta el {{date .Time}}.{{else}}Se cambió un ajuste de seguridad de tu cuenta.{{end}}" +
+				"</p><p>Si no fuiste tú, contacta con soporte.</p>",
+		},
+	},
+	"zh": {
+		TypeVerify: {
+			Subject: "验证您的邮箱",
+			Text:    "{{.UserName}}，您好：\n\n您的验证码是 {{.VerifyCode}}。\n",
+			HTML:    "<p>{{.UserName}}，您好：</p><p>您的验证码是 <b>{{.VerifyCode}}</b>。</p>",
+		},
+		TypeEmailChanged: {
+			Subject: "您的邮箱已更改",
+			Text: "{{.UserName}}，您好：\n\n您账户的邮箱已更改为 {{.NewEmail}}。\n" +
+				"{{if .RevertLink}}如果这不是您本人的操作，请通过以下链接撤销：\n\n{{.RevertLink}}\n{{end}}",
+			HTML: "<p>{{.UserName}}，您好：</p><p>您账户的邮箱已更改为 {{.NewEmail}}。</p>" +
+				"{{if .RevertLink}}<p>如果这不是您本人的操作，请<a href=\"{{.RevertLink}}\">撤销更改</a>。</p>{{end}}",
+		},
+		TypePasswordReset: {
+			Subject: "重置您的密码",
+			Text:    "{{.UserName}}，您好：\n\n请通过以下链接重置密码：\n\n{{.Link}}\n",
+			HTML:    "<p>{{.UserName}}，您好：</p><p><a href=\"{{.Link}}\">重置密码</a>。</p>",
+		},
+		TypeLockout: {
+			Subject: "您的账户已被锁定",
+			Text:    "{{.UserName}}，您好：\n\n由于登录失败次数过多，您的账户已被锁定，将于 {{date .Time}} 解锁。\n",
+			HTML:    "<p>{{.UserName}}，您好：</p><p>由于登录失败次数过多，您的账户已被锁定，将于 {{date .Time}} 解锁。</p>",
+		},
+		TypeInvitation: {
+			Subject: "邀请您注册",
+			Text:    "您好：\n\n{{.Detail}} 邀请您创建账户，请通过以下链接注册：\n\n{{.Link}}\n",
+			HTML:    "<p>您好：</p><p>{{.Detail}} 邀请您创建账户，<a href=\"{{.Link}}\">立即注册</a>。</p>",
+		},
+		TypeSecurityAlert: {
+			Subject: "账户安全提醒",
+			Text: "{{.UserName}}，您好：\n\n" +
+				"{{if eq .Event \"password_changed\"}}您账户的密码已更改。{{else if eq .Event \"account_deleted\"}}" +
+				"您的账户已删除，可在 {{date .Time}} 之前恢复。{{else}}您账户的安全设置已更改。{{end}}" +
+				"\n如果这不是您本人的操作，请联系客服。\n",
+			HTML: "<p>{{.UserName}}，您好：</p><p>" +
+				"{{if eq .Event \"password_changed\"}}您账户的密码已更改。{{else if eq .Event \"account_deleted\"}}" +
+				"您的账户已删除，可在 {{date .Time}} 之前恢复。{{else}}您账户的安全设置已更改。{{end}}" +
+				"</p><p>如果这不是您本人的操作，请联系客服。</p>",
+		},
+	},
+}
@@ -0,0 +1,53 @@
+package verify
+
+import (
+	"fmt"
+)
+
+// Notifier delivers notifications (verification codes, security notices)
+// to users
+type Notifier interface {
+	Notify(req VerifyRequest) error
+}
+
+// Config selects and configures a Notifier
+type Config struct {
+	// Kind is one of "http", "smtp" or "log"
+	Kind string
+	// Endpoint of the email service for the http notifier
+	Endpoint string
+	SMTP     SMTPConfig
+	// LogFile the log notifier appends to, empty logs with glog
+	LogFile string
+	// TemplateDir overrides the built-in message templates
+	TemplateDir string
+	// Fallbacks are the locales tried when the user's locale has no template
+	Fallbacks []string
+}
+
+// NewNotifier creates the notifier selected by the config, messages are
+// rendered from the templates in the user's locale before delivery
+func NewNotifier(cfg Config) (Notifier, error) {
+	templates, err := NewTemplates(cfg.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+	var next Notifier
+	switch cfg.Kind {
+	case "http", "":
+		next = HTTPNotifier{Endpoint: cfg.Endpoint}
+	case "smtp":
+		if cfg.SMTP.Host == "" || cfg.SMTP.From == "" {
+			return nil, fmt.Errorf("smtp notifier needs a host and a from address")
+		}
+		next = SMTPNotifier{Config: cfg.SMTP}
+	case "log":
+		next, err = NewLogNotifier(cfg.LogFile)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", cfg.Kind)
+	}
+	return localized{next: next, templates: templates, fallbacks: cfg.Fallbacks}, nil
+}
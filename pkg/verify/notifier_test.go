@@ -0,0 +1,63 @@
+package verify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "muser")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "notify.log")
+	notifier, err := NewNotifier(Config{Kind: "log", LogFile: path})
+	assert.Nil(t, err)
+	req := VerifyRequest{Type: TypeVerify, UserName: "test_user", To: "test@example.com", VerifyCode: "123456"}
+	assert.Nil(t, notifier.Notify(req))
+	assert.Nil(t, defaultTemplates(t).Render(&req, nil))
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	logged := VerifyRequest{}
+	assert.Nil(t, json.Unmarshal(data, &logged))
+	assert.Equal(t, logged, req, "one json line per notification")
+}
+
+func TestBuildMail(t *testing.T) {
+	req := VerifyRequest{Type: TypeVerify, UserName: "test_user", To: "test@example.com\r\nBcc: evil@example.com", VerifyCode: "123456"}
+	assert.Nil(t, defaultTemplates(t).Render(&req, nil))
+	mail := string(buildMail("noreply@example.com", req))
+	assert.Equal(t, strings.Contains(mail, "\r\nBcc:"), false, "no header injection")
+	assert.Equal(t, strings.Contains(mail, "123456"), true, "code in the body")
+	assert.Equal(t, strings.Contains(mail, "multipart/alternative"), true, "html alternative")
+}
+
+func TestUnknownNotifier(t *testing.T) {
+	_, err := NewNotifier(Config{Kind: "pigeon"})
+	assert.NotNil(t, err)
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	// a server accepting the connection but never greeting
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	notifier := SMTPNotifier{Config: SMTPConfig{Host: "127.0.0.1", Port: addr.Port, Timeout: 100 * time.Millisecond}}
+	start := time.Now()
+	err = notifier.Notify(VerifyRequest{Type: TypeVerify, To: "test@example.com"})
+	assert.NotNil(t, err)
+	assert.Equal(t, time.Since(start) < time.Second, true, "gives up at the timeout")
+}
@@ -0,0 +1,155 @@
+package verify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// OutboxEntry is a notification recorded in the same write as the user
+// change that caused it, waiting to be delivered by the OutboxWorker
+type OutboxEntry struct {
+	ID       string        `json:"id"`
+	Request  VerifyRequest `json:"request"`
+	Created  int64         `json:"created"`
+	Attempts int           `json:"attempts"`
+	// NextAttempt is zero once the entry is dead
+	NextAttempt int64  `json:"next_attempt,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	// Dead entries ran out of attempts and are kept for inspection only,
+	// without their secrets
+	Dead bool `json:"dead,omitempty"`
+	// Sealed holds the secrets of Request encrypted, see Sealer
+	Sealed string `json:"sealed,omitempty"`
+	// Tenant of the user the entry is about, empty for the default tenant
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// NewOutboxEntry wraps a notification for the outbox, due immediately
+func NewOutboxEntry(req VerifyRequest) OutboxEntry {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	now := time.Now().Unix()
+	return OutboxEntry{
+		ID:          hex.EncodeToString(b),
+		Request:     req,
+		Created:     now,
+		NextAttempt: now,
+	}
+}
+
+// OutboxStore keeps the outbox entries
+type OutboxStore interface {
+	// PendingNotifications returns the entries due at now which are not dead
+	PendingNotifications(now int64) ([]OutboxEntry, error)
+	// ClaimNotification counts an attempt and hides the entry until the
+	// given time, it returns false if another worker claimed it first
+	ClaimNotification(entry *OutboxEntry, until int64) (bool, error)
+	SaveNotification(entry *OutboxEntry) error
+	DeleteNotification(id string) error
+}
+
+// OutboxWorker delivers outbox entries in the background, retrying with
+// exponential backoff until MaxAttempts
+type OutboxWorker struct {
+	Store    OutboxStore
+	Notifier Notifier
+	// Sealer opens the secrets of sealed entries
+	Sealer *Sealer
+	// Interval between two polls of the outbox
+	Interval    time.Duration
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled on every retry
+	Backoff time.Duration
+	// Lease is how long a claimed entry is hidden from other workers
+	Lease time.Duration
+}
+
+// NewOutboxWorker creates a worker with default retry settings
+func NewOutboxWorker(store OutboxStore, notifier Notifier) *OutboxWorker {
+	return &OutboxWorker{
+		Store:       store,
+		Notifier:    notifier,
+		Interval:    5 * time.Second,
+		MaxAttempts: 10,
+		Backoff:     30 * time.Second,
+		Lease:       time.Minute,
+	}
+}
+
+// Run polls the outbox until stop is closed
+func (w *OutboxWorker) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		w.DispatchOnce()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchOnce tries to deliver all due entries once
+func (w *OutboxWorker) DispatchOnce() {
+	now := time.Now()
+	entries, err := w.Store.PendingNotifications(now.Unix())
+	if err != nil {
+		glog.Warningf("Failed to read the outbox: %v", err)
+		return
+	}
+	for i := range entries {
+		entry := &entries[i]
+		claimed, err := w.Store.ClaimNotification(entry, now.Add(w.Lease).Unix())
+		if err != nil || !claimed {
+			continue
+		}
+		req, err := w.open(entry)
+		if err == nil {
+			err = w.Notifier.Notify(req)
+		} else {
+			// a key change can't be retried away
+			entry.Attempts = w.MaxAttempts
+		}
+		if err == nil {
+			err = w.Store.DeleteNotification(entry.ID)
+			if err != nil {
+				glog.Warningf("Failed to delete delivered notification %s: %v", entry.ID, err)
+			}
+			continue
+		}
+		glog.Warningf("Failed to deliver %s notification %s (attempt %d): %v",
+			entry.Request.Type, entry.ID, entry.Attempts, err)
+		entry.LastError = err.Error()
+		if entry.Attempts >= w.MaxAttempts {
+			entry.Dead = true
+			entry.NextAttempt = 0
+			entry.Redact()
+		} else {
+			entry.NextAttempt = now.Add(w.Backoff << uint(entry.Attempts-1)).Unix()
+		}
+		err = w.Store.SaveNotification(entry)
+		if err != nil {
+			glog.Warningf("Failed to save notification %s: %v", entry.ID, err)
+		}
+	}
+}
+
+// open returns the request of an entry with its secrets
+func (w *OutboxWorker) open(entry *OutboxEntry) (VerifyRequest, error) {
+	if entry.Sealed == "" {
+		return entry.Request, nil
+	}
+	if w.Sealer == nil {
+		return entry.Request, errors.New("no key to open sealed secrets")
+	}
+	return w.Sealer.Open(entry)
+}
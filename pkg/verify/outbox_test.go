@@ -0,0 +1,140 @@
+package verify
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryOutbox struct {
+	entries map[string]OutboxEntry
+}
+
+func (m *memoryOutbox) PendingNotifications(now int64) ([]OutboxEntry, error) {
+	pending := []OutboxEntry{}
+	for _, entry := range m.entries {
+		if !entry.Dead && entry.NextAttempt <= now {
+			pending = append(pending, entry)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memoryOutbox) ClaimNotification(entry *OutboxEntry, until int64) (bool, error) {
+	stored := m.entries[entry.ID]
+	if stored.Attempts != entry.Attempts {
+		return false, nil
+	}
+	entry.Attempts++
+	entry.NextAttempt = until
+	m.entries[entry.ID] = *entry
+	return true, nil
+}
+
+func (m *memoryOutbox) SaveNotification(entry *OutboxEntry) error {
+	m.entries[entry.ID] = *entry
+	return nil
+}
+
+func (m *memoryOutbox) DeleteNotification(id string) error {
+	delete(m.entries, id)
+	return nil
+}
+
+type failingNotifier struct {
+	err error
+}
+
+func (n failingNotifier) Notify(req VerifyRequest) error {
+	return n.err
+}
+
+func TestOutboxDelivered(t *testing.T) {
+	entry := NewOutboxEntry(VerifyRequest{Type: TypeVerify, To: "test@example.com"})
+	store := &memoryOutbox{entries: map[string]OutboxEntry{entry.ID: entry}}
+	w := NewOutboxWorker(store, failingNotifier{})
+	w.DispatchOnce()
+	assert.Equal(t, len(store.entries), 0, "delivered entries are removed")
+}
+
+func TestOutboxRetryThenDead(t *testing.T) {
+	entry := NewOutboxEntry(VerifyRequest{Type: TypeVerify, To: "test@example.com"})
+	store := &memoryOutbox{entries: map[string]OutboxEntry{entry.ID: entry}}
+	w := NewOutboxWorker(store, failingNotifier{err: errors.New("mail server down")})
+	w.MaxAttempts = 2
+	w.Backoff = 0
+	w.DispatchOnce()
+	retried := store.entries[entry.ID]
+	assert.Equal(t, retried.Attempts, 1)
+	assert.Equal(t, retried.Dead, false, "kept for a retry")
+	assert.Equal(t, retried.LastError, "mail server down")
+
+	w.DispatchOnce()
+	assert.Equal(t, store.entries[entry.ID].Dead, true, "out of attempts")
+	assert.Equal(t, store.entries[entry.ID].NextAttempt, int64(0), "dead entries leave the pending index")
+	w.DispatchOnce()
+	assert.Equal(t, store.entries[entry.ID].Attempts, 2, "dead entries are not retried")
+}
+
+func TestOutboxSealed(t *testing.T) {
+	sealer, err := NewSealer(make([]byte, 32))
+	assert.Nil(t, err)
+	entry := NewOutboxEntry(VerifyRequest{Type: TypeVerify, To: "test@example.com", VerifyCode: "123456",
+		Link: "https://example.com/reset?token=secret"})
+	assert.Nil(t, sealer.Seal(&entry))
+	assert.Equal(t, entry.Request.VerifyCode, "", "no plain code")
+	assert.Equal(t, entry.Request.Link, "", "no plain link")
+	req, err := sealer.Open(&entry)
+	assert.Nil(t, err)
+	assert.Equal(t, req.VerifyCode, "123456")
+	assert.Equal(t, req.Link, "https://example.com/reset?token=secret")
+
+	other := NewOutboxEntry(VerifyRequest{})
+	other.Sealed = entry.Sealed
+	_, err = sealer.Open(&other)
+	assert.NotNil(t, err, "bound to the entry ID")
+}
+
+type recordingNotifier struct {
+	sent []VerifyRequest
+}
+
+func (n *recordingNotifier) Notify(req VerifyRequest) error {
+	n.sent = append(n.sent, req)
+	return nil
+}
+
+func TestOutboxOpensSealed(t *testing.T) {
+	sealer, _ := NewSealer(make([]byte, 32))
+	entry := NewOutboxEntry(VerifyRequest{Type: TypeVerify, To: "test@example.com", VerifyCode: "123456"})
+	sealer.Seal(&entry)
+	store := &memoryOutbox{entries: map[string]OutboxEntry{entry.ID: entry}}
+	notifier := &recordingNotifier{}
+	w := NewOutboxWorker(store, notifier)
+	w.Sealer = sealer
+	w.DispatchOnce()
+	assert.Equal(t, len(notifier.sent), 1)
+	assert.Equal(t, notifier.sent[0].VerifyCode, "123456", "opened for delivery")
+}
+
+func TestOutboxDeadRedacted(t *testing.T) {
+	sealer, _ := NewSealer(make([]byte, 32))
+	entry := NewOutboxEntry(VerifyRequest{Type: TypeVerify, To: "test@example.com", VerifyCode: "123456"})
+	sealer.Seal(&entry)
+	store := &memoryOutbox{entries: map[string]OutboxEntry{entry.ID: entry}}
+	w := NewOutboxWorker(store, failingNotifier{err: errors.New("mail server down")})
+	w.Sealer = sealer
+	w.MaxAttempts = 1
+	w.DispatchOnce()
+	dead := store.entries[entry.ID]
+	assert.Equal(t, dead.Dead, true)
+	assert.Equal(t, dead.Sealed, "", "secrets dropped")
+
+	entry = NewOutboxEntry(VerifyRequest{Type: TypeVerify, To: "test@example.com"})
+	sealer.Seal(&entry)
+	store = &memoryOutbox{entries: map[string]OutboxEntry{entry.ID: entry}}
+	w = NewOutboxWorker(store, failingNotifier{})
+	w.DispatchOnce()
+	assert.Equal(t, store.entries[entry.ID].Dead, true, "can't be opened without the key")
+}
@@ -0,0 +1,99 @@
+package verify
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotSealed is returned when opening an entry without sealed secrets
+var ErrNotSealed = errors.New("entry has no sealed secrets")
+
+// Sealer encrypts the secrets of outbox entries, the verify code and the
+// links, so the outbox never holds them in plain text. Only the worker
+// delivering an entry opens it.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// secrets are the fields of a VerifyRequest which are sealed
+type secrets struct {
+	VerifyCode string `json:"verify_code,omitempty"`
+	RevertLink string `json:"revert_link,omitempty"`
+	Link       string `json:"link,omitempty"`
+}
+
+// NewSealer creates a sealer using AES-256-GCM with a 32 bytes key
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("the key has %d bytes, 32 are needed", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal moves the secrets of the entry's request to its Sealed field,
+// encrypted and bound to the entry ID
+func (s *Sealer) Seal(entry *OutboxEntry) error {
+	req := &entry.Request
+	plain, err := json.Marshal(secrets{VerifyCode: req.VerifyCode, RevertLink: req.RevertLink, Link: req.Link})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nonce, nonce, plain, []byte(entry.ID))
+	entry.Sealed = base64.RawURLEncoding.EncodeToString(sealed)
+	req.VerifyCode, req.RevertLink, req.Link = "", "", ""
+	return nil
+}
+
+// Open returns the entry's request with its secrets decrypted, the entry
+// itself keeps them sealed
+func (s *Sealer) Open(entry *OutboxEntry) (VerifyRequest, error) {
+	req := entry.Request
+	if entry.Sealed == "" {
+		return req, ErrNotSealed
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(entry.Sealed)
+	if err != nil {
+		return req, err
+	}
+	size := s.aead.NonceSize()
+	if len(sealed) < size {
+		return req, errors.New("sealed secrets are too short")
+	}
+	plain, err := s.aead.Open(nil, sealed[:size], sealed[size:], []byte(entry.ID))
+	if err != nil {
+		return req, err
+	}
+	sec := secrets{}
+	err = json.Unmarshal(plain, &sec)
+	if err != nil {
+		return req, err
+	}
+	req.VerifyCode, req.RevertLink, req.Link = sec.VerifyCode, sec.RevertLink, sec.Link
+	return req, nil
+}
+
+// Redact drops the secrets of an entry which will never be delivered
+func (entry *OutboxEntry) Redact() {
+	entry.Sealed = ""
+	entry.Request.VerifyCode = ""
+	entry.Request.RevertLink = ""
+	entry.Request.Link = ""
+}
@@ -0,0 +1,135 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// SMTPConfig is the mail server the SMTP notifier delivers to
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	UserName string
+	Password string
+	From     string
+	// NoTLS allows plain text delivery when the server lacks STARTTLS
+	NoTLS bool
+	// Timeout bounds the whole delivery of a mail, DefaultSMTPTimeout if 0
+	Timeout time.Duration
+}
+
+// DefaultSMTPTimeout is the time a delivery may take by default, a stuck
+// server must not stall the outbox worker
+const DefaultSMTPTimeout = 30 * time.Second
+
+// SMTPNotifier sends notifications directly through a mail server
+type SMTPNotifier struct {
+	Config SMTPConfig
+}
+
+func (n SMTPNotifier) Notify(req VerifyRequest) error {
+	if req.To == "" {
+		return fmt.Errorf("no recipient for %s notification", req.Type)
+	}
+	cfg := n.Config
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultSMTPTimeout
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		glog.Warningf("error connecting to smtp server %s: %v", addr, err)
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		glog.Warningf("error greeting smtp server %s: %v", addr, err)
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: cfg.Host})
+		if err != nil {
+			glog.Warningf("error starting tls with %s: %v", addr, err)
+			return err
+		}
+	} else if !cfg.NoTLS {
+		return fmt.Errorf("smtp server %s does not support STARTTLS", addr)
+	}
+	if cfg.UserName != "" {
+		err = c.Auth(smtp.PlainAuth("", cfg.UserName, cfg.Password, cfg.Host))
+		if err != nil {
+			glog.Warningf("error authenticating with %s: %v", addr, err)
+			return err
+		}
+	}
+	err = c.Mail(cfg.From)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(req.To)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buildMail(cfg.From, req))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMail formats the rendered notification as a mail, with an html
+// alternative when the template has one
+func buildMail(from string, req VerifyRequest) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", headerValue(req.To))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(req.Subject)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if req.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		buf.WriteString(crlf(req.Text))
+		return buf.Bytes()
+	}
+	boundary := "muser-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", boundary, crlf(req.Text))
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", boundary, crlf(req.HTML))
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+// crlf converts line breaks of a body to the ones required by SMTP
+func crlf(body string) string {
+	return strings.Replace(strings.Replace(body, "\r\n", "\n", -1), "\n", "\r\n", -1)
+}
+
+// headerValue drops line breaks so values can not inject headers
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
@@ -0,0 +1,232 @@
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	htemplate "html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	ttemplate "text/template"
+	"time"
+)
+
+// DefaultLocale is the last fallback when rendering a message
+const DefaultLocale = "en"
+
+// defaultDateLayout writes times in locales without a layout of their own
+const defaultDateLayout = "2006-01-02 15:04 UTC"
+
+// dateLayouts are the ways languages write a date and time, looked up by the
+// locale of the template then its base language, so dates read like the
+// text around them. Month names are only spelled out in English.
+var dateLayouts = map[string]string{
+	"en": "January 2, 2006 15:04 UTC",
+	"es": "2/1/2006 15:04 UTC",
+	"zh": "2006年1月2日 15:04 UTC",
+}
+
+// funcs are the functions of the templates of locale
+func funcs(locale string) map[string]interface{} {
+	layout, ok := dateLayouts[locale]
+	if !ok {
+		layout, ok = dateLayouts[strings.SplitN(locale, "-", 2)[0]]
+	}
+	if !ok {
+		layout = defaultDateLayout
+	}
+	return map[string]interface{}{
+		// date writes a unix time the way the language of the template does
+		"date": func(t int64) string {
+			return time.Unix(t, 0).UTC().Format(layout)
+		},
+	}
+}
+
+// normalizeLocale is the form locales are compared in: lower case, with
+// "-" separating the region, so pt_BR and pt-br are the same
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
+
+// message is the source of one notification type in one locale
+type message struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// compiled is a parsed message
+type compiled struct {
+	subject *ttemplate.Template
+	text    *ttemplate.Template
+	html    *htemplate.Template
+}
+
+// Templates renders notifications from per locale message templates
+type Templates struct {
+	messages map[string]compiled
+}
+
+// NewTemplates returns the built-in templates, overridden by files under
+// dir if dir is not empty. Files are laid out as <dir>/<locale>/<type>.subject,
+// <type>.txt and <type>.html, missing parts keep the built-in version.
+func NewTemplates(dir string) (*Templates, error) {
+	sources := map[string]message{}
+	for locale, messages := range builtinMessages {
+		for kind, msg := range messages {
+			sources[locale+"/"+kind] = msg
+		}
+	}
+	if dir != "" {
+		err := loadMessages(dir, sources)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t := &Templates{messages: map[string]compiled{}}
+	for key, msg := range sources {
+		c, err := compile(key, msg)
+		if err != nil {
+			return nil, err
+		}
+		t.messages[key] = c
+	}
+	return t, nil
+}
+
+func loadMessages(dir string, sources map[string]message) error {
+	locales, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(dir, locale.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			ext := filepath.Ext(file.Name())
+			kind := strings.TrimSuffix(file.Name(), ext)
+			data, err := ioutil.ReadFile(filepath.Join(dir, locale.Name(), file.Name()))
+			if err != nil {
+				return err
+			}
+			key := normalizeLocale(locale.Name()) + "/" + kind
+			msg := sources[key]
+			switch ext {
+			case ".subject":
+				msg.Subject = strings.TrimSpace(string(data))
+			case ".txt":
+				msg.Text = string(data)
+			case ".html":
+				msg.HTML = string(data)
+			default:
+				continue
+			}
+			sources[key] = msg
+		}
+	}
+	return nil
+}
+
+func compile(key string, msg message) (compiled, error) {
+	c := compiled{}
+	var err error
+	fns := funcs(strings.SplitN(key, "/", 2)[0])
+	if msg.Subject == "" || msg.Text == "" {
+		return c, fmt.Errorf("template %s needs a subject and a text body", key)
+	}
+	c.subject, err = ttemplate.New(key + ".subject").Funcs(fns).Parse(msg.Subject)
+	if err != nil {
+		return c, err
+	}
+	c.text, err = ttemplate.New(key + ".txt").Funcs(fns).Parse(msg.Text)
+	if err != nil {
+		return c, err
+	}
+	if msg.HTML != "" {
+		c.html, err = htemplate.New(key + ".html").Funcs(fns).Parse(msg.HTML)
+		if err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// Candidates lists the locales tried for a message, most specific first:
+// the requested locale, its base language, then the fallbacks
+func Candidates(locale string, fallbacks []string) []string {
+	candidates := []string{}
+	add := func(l string) {
+		l = normalizeLocale(l)
+		if l == "" {
+			return
+		}
+		for _, c := range candidates {
+			if c == l {
+				return
+			}
+		}
+		candidates = append(candidates, l)
+	}
+	locale = normalizeLocale(locale)
+	add(locale)
+	if i := strings.Index(locale, "-"); i > 0 {
+		add(locale[:i])
+	}
+	for _, l := range fallbacks {
+		add(l)
+	}
+	add(DefaultLocale)
+	return candidates
+}
+
+// Render fills the subject and bodies of req from the first locale that has
+// a template for req.Type
+func (t *Templates) Render(req *VerifyRequest, fallbacks []string) error {
+	for _, locale := range Candidates(req.Locale, fallbacks) {
+		c, ok := t.messages[locale+"/"+req.Type]
+		if !ok {
+			continue
+		}
+		var subject, text, html bytes.Buffer
+		err := c.subject.Execute(&subject, req)
+		if err != nil {
+			return err
+		}
+		err = c.text.Execute(&text, req)
+		if err != nil {
+			return err
+		}
+		if c.html != nil {
+			err = c.html.Execute(&html, req)
+			if err != nil {
+				return err
+			}
+		}
+		req.Subject = subject.String()
+		req.Text = text.String()
+		req.HTML = html.String()
+		return nil
+	}
+	return fmt.Errorf("no template for %s notification", req.Type)
+}
+
+// localized renders notifications before handing them to the next notifier
+type localized struct {
+	next      Notifier
+	templates *Templates
+	fallbacks []string
+}
+
+func (n localized) Notify(req VerifyRequest) error {
+	err := n.templates.Render(&req, n.fallbacks)
+	if err != nil {
+		return err
+	}
+	return n.next.Notify(req)
+}
@@ -0,0 +1,125 @@
+package verify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func defaultTemplates(t *testing.T) *Templates {
+	templates, err := NewTemplates("")
+	assert.Nil(t, err)
+	return templates
+}
+
+func TestCandidates(t *testing.T) {
+	assert.Equal(t, Candidates("pt_BR", []string{"es"}), []string{"pt-br", "pt", "es", "en"})
+	assert.Equal(t, Candidates("", nil), []string{"en"}, "default locale only")
+	assert.Equal(t, Candidates("en-US", []string{"en"}), []string{"en-us", "en"}, "no duplicates")
+}
+
+func TestRenderFallback(t *testing.T) {
+	templates := defaultTemplates(t)
+	req := VerifyRequest{Type: TypeVerify, UserName: "test_user", Locale: "es-MX", VerifyCode: "123456"}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, req.Subject, "Verifica tu correo", "falls back to the base language")
+	req = VerifyRequest{Type: TypeVerify, UserName: "test_user", Locale: "fr", VerifyCode: "123456"}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, req.Subject, "Verify your email", "falls back to the default locale")
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	req := VerifyRequest{Type: TypeVerify, UserName: "<script>", VerifyCode: "123456"}
+	assert.Nil(t, defaultTemplates(t).Render(&req, nil))
+	assert.Equal(t, strings.Contains(req.HTML, "<script>"), false)
+	assert.Equal(t, strings.Contains(req.Text, "<script>"), true, "text is not escaped")
+}
+
+func TestTemplateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "muser")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "fr"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fr", TypeVerify+".subject"), []byte("Vérifiez votre email\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fr", TypeVerify+".txt"), []byte("Code {{.VerifyCode}}"), 0600))
+	templates, err := NewTemplates(dir)
+	assert.Nil(t, err)
+	req := VerifyRequest{Type: TypeVerify, Locale: "fr", VerifyCode: "123456"}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, req.Subject, "Vérifiez votre email")
+	assert.Equal(t, req.Text, "Code 123456")
+}
+
+func TestTemplateDirRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "muser")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "pt_BR"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "pt_BR", TypeVerify+".subject"), []byte("Verifique seu email"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "pt_BR", TypeVerify+".txt"), []byte("Código {{.VerifyCode}}"), 0600))
+	templates, err := NewTemplates(dir)
+	assert.Nil(t, err)
+	for _, locale := range []string{"pt_BR", "pt-BR", "pt-br"} {
+		req := VerifyRequest{Type: TypeVerify, Locale: locale, VerifyCode: "123456"}
+		assert.Nil(t, templates.Render(&req, nil))
+		assert.Equal(t, req.Subject, "Verifique seu email", locale)
+	}
+}
+
+func TestRenderSecurityAlert(t *testing.T) {
+	templates := defaultTemplates(t)
+	req := VerifyRequest{Type: TypeSecurityAlert, UserName: "test_user", Locale: "es", Event: EventPasswordChanged}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, strings.Contains(req.Text, "La contraseña de tu cuenta ha cambiado."), true, "described in the locale")
+	req = VerifyRequest{Type: TypeSecurityAlert, UserName: "test_user", Locale: "zh", Event: EventAccountDeleted, Time: 1600000000}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, strings.Contains(req.Text, "2020年9月13日 12:26 UTC"), true, "time written by the template")
+	assert.Equal(t, strings.Contains(req.HTML, "您的账户已删除"), true)
+	req = VerifyRequest{Type: TypeSecurityAlert, UserName: "test_user", Locale: "es", Event: "mfa_disabled", Detail: "MFA was disabled"}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, strings.Contains(req.Text, "Se cambió un ajuste de seguridad"), true, "unknown events in the locale")
+	assert.Equal(t, strings.Contains(req.Text, "MFA"), false, "not the detail of the caller")
+}
+
+func TestRenderLockout(t *testing.T) {
+	templates := defaultTemplates(t)
+	for locale, unlock := range map[string]string{
+		"":      "unlocked on September 13, 2020 12:26 UTC",
+		"es-MX": "Se desbloqueará el 13/9/2020 12:26 UTC",
+		"zh":    "将于 2020年9月13日 12:26 UTC 解锁",
+	} {
+		req := VerifyRequest{Type: TypeLockout, UserName: "test_user", Locale: locale, Time: 1600000000}
+		assert.Nil(t, templates.Render(&req, nil))
+		assert.Equal(t, strings.Contains(req.Text, unlock), true, locale+": "+req.Text)
+		assert.Equal(t, strings.Contains(req.HTML, unlock), true, locale+": "+req.HTML)
+	}
+}
+
+func TestTemplateDirDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "muser")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "fr"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fr", TypeLockout+".subject"), []byte("Compte bloqué"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fr", TypeLockout+".txt"), []byte("Débloqué le {{date .Time}}"), 0600))
+	templates, err := NewTemplates(dir)
+	assert.Nil(t, err)
+	req := VerifyRequest{Type: TypeLockout, Locale: "fr", Time: 1600000000}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, req.Text, "Débloqué le 2020-09-13 12:26 UTC", "no layout for the locale")
+}
+
+func TestRenderEmailChanged(t *testing.T) {
+	templates := defaultTemplates(t)
+	req := VerifyRequest{Type: TypeEmailChanged, UserName: "test_user", NewEmail: "new@example.com", RevertLink: "https://example.com/revert"}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, strings.Contains(req.HTML, "https://example.com/revert"), true, "with the revert link")
+	req = VerifyRequest{Type: TypeEmailChanged, UserName: "test_user", NewEmail: "new@example.com"}
+	assert.Nil(t, templates.Render(&req, nil))
+	assert.Equal(t, strings.Contains(req.Text, "revert"), false, "no revert without a link")
+	assert.Equal(t, strings.Contains(req.HTML, "href"), false, "no revert without a link")
+}
@@ -1,7 +1,44 @@
 package verify
 
+const (
+	// TypeVerify asks the email service to send a verification code
+	TypeVerify = "verify"
+	// TypeEmailChanged notifies the previous address of an email change
+	TypeEmailChanged = "email_changed"
+	// TypePasswordReset sends a link to reset the password
+	TypePasswordReset = "password_reset"
+	// TypeLockout tells the user the account got locked
+	TypeLockout = "lockout"
+	// TypeInvitation sends an invitation to register, Detail is the inviter
+	TypeInvitation = "invitation"
+	// TypeSecurityAlert tells the user about the sensitive change in Event
+	TypeSecurityAlert = "security_alert"
+)
+
+// Events of security alerts, the templates describe them in the user's
+// language
+const (
+	EventPasswordChanged = "password_changed"
+	// EventAccountDeleted is a deletion restorable until Time
+	EventAccountDeleted = "account_deleted"
+)
+
 type VerifyRequest struct {
+	Type       string `json:"type,omitempty"`
 	UserName   string `json:"user_name,omitempty"`
 	To         string `json:"to,omitempty"`
+	Locale     string `json:"locale,omitempty"`
 	VerifyCode string `json:"verify_code,omitempty"`
+	NewEmail   string `json:"new_email,omitempty"`
+	RevertLink string `json:"revert_link,omitempty"`
+	Link       string `json:"link,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	// Event is what a security alert is about
+	Event string `json:"event,omitempty"`
+	// Time is a unix time the message tells, e.g. the end of a lock
+	Time int64 `json:"time,omitempty"`
+	// rendered from the templates of the user's locale
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
 }
@@ -0,0 +1,61 @@
+package webhook
+
+// Event types delivered to subscribers
+const (
+	UserRegistered      = "user.registered"
+	UserVerified        = "user.verified"
+	UserEmailChanged    = "user.email_changed"
+	UserPasswordChanged = "user.password_changed"
+	UserDeleted         = "user.deleted"
+	UserRenamed         = "user.renamed"
+)
+
+// Event is the json body posted to subscribers
+type Event struct {
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Created  int64                  `json:"created"`
+	Tenant   string                 `json:"tenant,omitempty"`
+	UserName string                 `json:"user_name,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// Subscription is an endpoint receiving the events it subscribed to,
+// signed with its secret
+type Subscription struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events,omitempty"`
+}
+
+// DeadLetter records an event that could not be delivered after all retries
+type DeadLetter struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	Event     Event  `json:"event"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+	Failed    int64  `json:"failed"`
+}
+
+// DeadLetterStore keeps dead letters until they are replayed
+type DeadLetterStore interface {
+	SaveDeadLetter(letter *DeadLetter) error
+	GetDeadLetter(id string) (*DeadLetter, error)
+	ListDeadLetters() ([]DeadLetter, error)
+	DeleteDeadLetter(id string) error
+}
+
+// Wants returns true if the subscription receives events of the type,
+// a subscription without events receives all of them
+func (sub Subscription) Wants(eventType string) bool {
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, e := range sub.Events {
+		if e == eventType || e == "*" {
+			return true
+		}
+	}
+	return false
+}
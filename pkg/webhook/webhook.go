@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC of "<timestamp>.<body>"
+	SignatureHeader = "X-Muser-Signature"
+	// TimestampHeader carries the unix time the request was signed at
+	TimestampHeader = "X-Muser-Timestamp"
+)
+
+// Sign computes the signature header value of a body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// LoadSubscriptions reads a json list of subscriptions from a file
+func LoadSubscriptions(path string) ([]Subscription, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	subs := []Subscription{}
+	err = json.Unmarshal(data, &subs)
+	if err != nil {
+		return nil, fmt.Errorf("bad webhook config %s: %v", path, err)
+	}
+	for _, sub := range subs {
+		if sub.URL == "" || sub.Secret == "" {
+			return nil, fmt.Errorf("bad webhook config %s: every subscription needs a url and a secret", path)
+		}
+	}
+	return subs, nil
+}
+
+// Dispatcher delivers events to the subscriptions in the background,
+// retrying with exponential backoff before recording a dead letter
+type Dispatcher struct {
+	Subscriptions []Subscription
+	DeadLetters   DeadLetterStore
+	// MaxAttempts is the number of deliveries tried before giving up
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled on every retry
+	Backoff time.Duration
+	Client  *http.Client
+}
+
+// NewDispatcher creates a dispatcher with default retry settings
+func NewDispatcher(subs []Subscription, deadLetters DeadLetterStore) *Dispatcher {
+	return &Dispatcher{
+		Subscriptions: subs,
+		DeadLetters:   deadLetters,
+		MaxAttempts:   5,
+		Backoff:       time.Second,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Publish sends a new event about a user of a tenant, empty for the default
+// tenant, to all subscriptions that want it
+func (d *Dispatcher) Publish(eventType string, tenant string, username string, data map[string]interface{}) {
+	event := Event{
+		ID:       newID(),
+		Type:     eventType,
+		Created:  time.Now().Unix(),
+		Tenant:   tenant,
+		UserName: username,
+		Data:     data,
+	}
+	for _, sub := range d.Subscriptions {
+		if sub.Wants(eventType) {
+			go d.deliverWithRetry(sub, event)
+		}
+	}
+}
+
+func (d *Dispatcher) deliverWithRetry(sub Subscription, event Event) {
+	var err error
+	wait := d.Backoff
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		err = d.deliver(sub, event)
+		if err == nil {
+			return
+		}
+		glog.Warningf("webhook %s to %s failed (attempt %d): %v", event.Type, sub.URL, attempt, err)
+		if attempt < d.MaxAttempts {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	letter := &DeadLetter{
+		ID:        newID(),
+		URL:       sub.URL,
+		Event:     event,
+		Attempts:  d.MaxAttempts,
+		LastError: err.Error(),
+		Failed:    time.Now().Unix(),
+	}
+	if d.DeadLetters == nil {
+		glog.Warningf("webhook %s to %s dropped, no dead letter store", event.ID, sub.URL)
+		return
+	}
+	err = d.DeadLetters.SaveDeadLetter(letter)
+	if err != nil {
+		glog.Warningf("failed to save dead letter for webhook %s: %v", event.ID, err)
+	}
+}
+
+func (d *Dispatcher) deliver(sub Subscription, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now, 10))
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, now, body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("subscriber returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Replay delivers a dead letter once more and removes it on success
+func (d *Dispatcher) Replay(id string) error {
+	letter, err := d.DeadLetters.GetDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	for _, sub := range d.Subscriptions {
+		if sub.URL != letter.URL {
+			continue
+		}
+		err = d.deliver(sub, letter.Event)
+		if err != nil {
+			return err
+		}
+		return d.DeadLetters.DeleteDeadLetter(id)
+	}
+	return fmt.Errorf("no subscription for %s anymore", letter.URL)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
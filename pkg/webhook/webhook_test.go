@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+	saved   chan string
+}
+
+func (m *memoryStore) SaveDeadLetter(letter *DeadLetter) error {
+	m.mu.Lock()
+	m.letters[letter.ID] = *letter
+	m.mu.Unlock()
+	m.saved <- letter.ID
+	return nil
+}
+
+func (m *memoryStore) GetDeadLetter(id string) (*DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	letter, ok := m.letters[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &letter, nil
+}
+
+func (m *memoryStore) ListDeadLetters() ([]DeadLetter, error) {
+	return nil, nil
+}
+
+func (m *memoryStore) DeleteDeadLetter(id string) error {
+	m.mu.Lock()
+	delete(m.letters, id)
+	m.mu.Unlock()
+	return nil
+}
+
+func TestSubscriptionWants(t *testing.T) {
+	assert.Equal(t, Subscription{}.Wants(UserRegistered), true, "all events by default")
+	sub := Subscription{Events: []string{UserVerified}}
+	assert.Equal(t, sub.Wants(UserVerified), true)
+	assert.Equal(t, sub.Wants(UserRegistered), false)
+}
+
+func TestDeliverSignedRetryAndReplay(t *testing.T) {
+	var mu sync.Mutex
+	fail := true
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		assert.Equal(t, r.Header.Get(SignatureHeader), Sign("secret", ts, body), "signed body")
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	store := &memoryStore{letters: map[string]DeadLetter{}, saved: make(chan string, 1)}
+	d := NewDispatcher([]Subscription{{URL: server.URL, Secret: "secret"}}, store)
+	d.MaxAttempts = 3
+	d.Backoff = time.Millisecond
+	d.Publish(UserRegistered, "", "test_user", nil)
+
+	var id string
+	select {
+	case id = <-store.saved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no dead letter recorded")
+	}
+	mu.Lock()
+	assert.Equal(t, calls, 3, "retried before giving up")
+	fail = false
+	mu.Unlock()
+
+	assert.Nil(t, d.Replay(id))
+	_, err := store.GetDeadLetter(id)
+	assert.NotNil(t, err, "removed after a successful replay")
+}